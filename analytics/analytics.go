@@ -0,0 +1,242 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/benjaminchristie/go-arxiv-tree/tree"
+)
+
+const (
+	DefaultDamping    = 0.85
+	DefaultIterations = 100
+	tolerance         = 1e-10
+)
+
+// Score is how central one paper is in the graph
+type Score struct {
+	ID        string
+	Title     string
+	Year      int
+	Depth     int
+	InDegree  int // papers in the graph citing it
+	OutDegree int // papers in the graph it cites
+	PageRank  float64
+}
+
+// InDegree counts the papers in g citing each paper
+func InDegree(g *tree.Graph) map[string]int {
+	d := map[string]int{}
+	for _, n := range g.Nodes() {
+		d[n.ID] = len(n.CitedBy)
+	}
+	return d
+}
+
+// PageRank ranks the papers in g, rank flowing from citing papers to the
+// ones they cite. Papers citing nothing in g, such as the unexpanded
+// frontier, spread their rank over every paper. It stops after
+// iterations rounds or once the ranks settle.
+func PageRank(g *tree.Graph, damping float64, iterations int) map[string]float64 {
+	nodes := g.Nodes()
+	n := float64(len(nodes))
+	rank := make(map[string]float64, len(nodes))
+	for _, v := range nodes {
+		rank[v.ID] = 1 / n
+	}
+	for i := 0; i < iterations; i++ {
+		dangling := 0.0
+		for _, v := range nodes {
+			if len(v.Refs) == 0 {
+				dangling += rank[v.ID]
+			}
+		}
+		next := make(map[string]float64, len(nodes))
+		for _, v := range nodes {
+			next[v.ID] = (1-damping)/n + damping*dangling/n
+		}
+		for _, v := range nodes {
+			share := damping * rank[v.ID] / float64(len(v.Refs))
+			for _, r := range v.Refs {
+				next[r] += share
+			}
+		}
+		delta := 0.0
+		for id, r := range next {
+			delta += math.Abs(r - rank[id])
+		}
+		rank = next
+		if delta < tolerance {
+			break
+		}
+	}
+	return rank
+}
+
+// Scores rates every paper in g, highest PageRank first
+func Scores(g *tree.Graph) []Score {
+	rank := PageRank(g, DefaultDamping, DefaultIterations)
+	var scores []Score
+	for _, n := range g.Nodes() {
+		scores = append(scores, Score{
+			ID:        n.ID,
+			Title:     n.Info.Title,
+			Year:      n.Info.Year(),
+			Depth:     n.Depth,
+			InDegree:  len(n.CitedBy),
+			OutDegree: len(n.Refs),
+			PageRank:  rank[n.ID],
+		})
+	}
+	SortBy(scores, "pagerank")
+	return scores
+}
+
+// Columns are the names SortBy accepts
+var Columns = []string{"pagerank", "cited", "cites", "year", "depth", "title", "id"}
+
+// SortBy orders scores by the named column, largest first for numbers and
+// alphabetically for text. Ties keep PageRank order.
+func SortBy(scores []Score, column string) error {
+	var less func(a, b Score) bool
+	switch strings.ToLower(column) {
+	case "pagerank":
+		less = func(a, b Score) bool { return a.PageRank > b.PageRank }
+	case "cited":
+		less = func(a, b Score) bool { return a.InDegree > b.InDegree }
+	case "cites":
+		less = func(a, b Score) bool { return a.OutDegree > b.OutDegree }
+	case "year":
+		less = func(a, b Score) bool { return a.Year > b.Year }
+	case "depth":
+		less = func(a, b Score) bool { return a.Depth < b.Depth }
+	case "title":
+		less = func(a, b Score) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) }
+	case "id":
+		less = func(a, b Score) bool { return a.ID < b.ID }
+	default:
+		return fmt.Errorf("unknown column %q, want one of %s", column, strings.Join(Columns, ", "))
+	}
+	sort.SliceStable(scores, func(i, j int) bool {
+		a, b := scores[i], scores[j]
+		if less(a, b) != less(b, a) {
+			return less(a, b)
+		}
+		if a.PageRank != b.PageRank {
+			return a.PageRank > b.PageRank
+		}
+		return a.ID < b.ID
+	})
+	return nil
+}
+
+// CentralUnread is the n highest ranked papers whose IDs are not in read,
+// leaving out the seeds, which the crawl started from. n <= 0 returns all.
+func CentralUnread(g *tree.Graph, read map[string]bool, n int) []Score {
+	var unread []Score
+	for _, s := range Scores(g) {
+		if s.Depth == 0 || read[s.ID] {
+			continue
+		}
+		unread = append(unread, s)
+	}
+	if n > 0 && len(unread) > n {
+		unread = unread[:n]
+	}
+	return unread
+}
+
+// Pair is how similar two papers are. Shared counts the papers both are
+// cited by, for co-citation, or both cite, for bibliographic coupling.
+// Similarity is Shared normalized to 0..1 by the cosine (Salton) measure.
+type Pair struct {
+	A, B       string // A sorts first, except in the pairs Similar returns
+	Shared     int
+	Similarity float64
+}
+
+// CoCitation pairs papers that are cited together by papers in g,
+// strongest first
+func CoCitation(g *tree.Graph) []Pair {
+	return pairs(g, func(n tree.Node) []string { return n.Refs }, func(n tree.Node) int { return len(n.CitedBy) })
+}
+
+// Coupling pairs papers in g that cite the same papers, strongest first
+func Coupling(g *tree.Graph) []Pair {
+	return pairs(g, func(n tree.Node) []string { return n.CitedBy }, func(n tree.Node) int { return len(n.Refs) })
+}
+
+// pairs counts, for every two papers appearing together in some group,
+// how many groups they share. size is what a paper could share at most.
+func pairs(g *tree.Graph, group func(tree.Node) []string, size func(tree.Node) int) []Pair {
+	nodes := g.Nodes()
+	sizes := make(map[string]int, len(nodes))
+	shared := map[[2]string]int{}
+	for _, n := range nodes {
+		sizes[n.ID] = size(n)
+		ids := append([]string(nil), group(n)...)
+		sort.Strings(ids)
+		for i := range ids {
+			for j := i + 1; j < len(ids); j++ {
+				shared[[2]string{ids[i], ids[j]}]++
+			}
+		}
+	}
+	ps := make([]Pair, 0, len(shared))
+	for k, s := range shared {
+		ps = append(ps, Pair{
+			A:          k[0],
+			B:          k[1],
+			Shared:     s,
+			Similarity: float64(s) / math.Sqrt(float64(sizes[k[0]]*sizes[k[1]])),
+		})
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		a, b := ps[i], ps[j]
+		switch {
+		case a.Shared != b.Shared:
+			return a.Shared > b.Shared
+		case a.Similarity != b.Similarity:
+			return a.Similarity > b.Similarity
+		case a.A != b.A:
+			return a.A < b.A
+		}
+		return a.B < b.B
+	})
+	return ps
+}
+
+// Similar is the pairs in ps that include id, with id as A
+func Similar(ps []Pair, id string) []Pair {
+	var out []Pair
+	for _, p := range ps {
+		switch id {
+		case p.A:
+			out = append(out, p)
+		case p.B:
+			out = append(out, Pair{A: p.B, B: p.A, Shared: p.Shared, Similarity: p.Similarity})
+		}
+	}
+	return out
+}
+
+// ReadList reads a list of papers already read, one arXiv ID or abs URL
+// per line. Blank lines and lines starting with # are skipped.
+func ReadList(filename string) (map[string]bool, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	read := map[string]bool{}
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		read[tree.CanonicalID(l)] = true
+	}
+	return read, nil
+}
@@ -0,0 +1,146 @@
+package analytics
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/benjaminchristie/go-arxiv-tree/tree"
+)
+
+// graph builds a graph rooted at the first paper named, every other paper
+// one level down, from citations written "a>b" for a citing b
+func graph(t *testing.T, citations ...string) *tree.Graph {
+	t.Helper()
+	s := tree.Snapshot{Version: tree.SnapshotVersion, Nodes: []tree.SnapshotNode{}}
+	seen := map[string]bool{}
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			depth := min(len(s.Nodes), 1) // only the root is a seed
+			s.Nodes = append(s.Nodes, tree.SnapshotNode{ID: id, Title: "Paper " + id, Depth: depth})
+		}
+	}
+	for _, c := range citations {
+		from, to, _ := strings.Cut(c, ">")
+		add(from)
+		add(to)
+		s.Edges = append(s.Edges, tree.Edge{From: from, To: to})
+	}
+	s.Root = s.Nodes[0].ID
+	g, err := s.Graph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestPageRankCycle(t *testing.T) {
+	rank := PageRank(graph(t, "a>b", "b>a"), DefaultDamping, DefaultIterations)
+	if !near(rank["a"], 0.5) || !near(rank["b"], 0.5) {
+		t.Errorf("ranks of a two paper cycle = %v, want 0.5 each", rank)
+	}
+}
+
+func TestPageRank(t *testing.T) {
+	g := graph(t, "a>b", "a>c", "b>c", "d>b", "d>c")
+	rank := PageRank(g, DefaultDamping, DefaultIterations)
+	sum := 0.0
+	for _, r := range rank {
+		sum += r
+	}
+	if !near(sum, 1) {
+		t.Errorf("ranks sum to %v, want 1", sum)
+	}
+	if !(rank["c"] > rank["b"] && rank["b"] > rank["a"] && near(rank["a"], rank["d"])) {
+		t.Errorf("ranks = %v, want c > b > a = d", rank)
+	}
+	// c cites nothing, so its rank is spread over every paper
+	want := map[string]float64{}
+	n := 4.0
+	for id := range rank {
+		want[id] = (1-DefaultDamping)/n + DefaultDamping*rank["c"]/n
+	}
+	want["b"] += DefaultDamping * (rank["a"]/2 + rank["d"]/2)
+	want["c"] += DefaultDamping * (rank["a"]/2 + rank["b"] + rank["d"]/2)
+	for id, r := range rank {
+		if !near(r, want[id]) {
+			t.Errorf("rank of %s = %v, not a fixed point, want %v", id, r, want[id])
+		}
+	}
+}
+
+func TestScores(t *testing.T) {
+	g := graph(t, "a>b", "a>c", "b>c", "d>b", "d>c")
+	scores := Scores(g)
+	if scores[0].ID != "c" || scores[0].InDegree != 3 || scores[0].OutDegree != 0 {
+		t.Errorf("top score = %+v, want c cited 3 times", scores[0])
+	}
+	if err := SortBy(scores, "cites"); err != nil {
+		t.Fatal(err)
+	}
+	if scores[0].OutDegree != 2 || scores[len(scores)-1].ID != "c" {
+		t.Errorf("sorted by cites = %+v", scores)
+	}
+	if err := SortBy(scores, "venue"); err == nil {
+		t.Error("SortBy an unknown column did not fail")
+	}
+}
+
+func TestCentralUnread(t *testing.T) {
+	g := graph(t, "a>b", "a>c", "b>c", "d>b", "d>c")
+	unread := CentralUnread(g, map[string]bool{"c": true}, 0)
+	for _, s := range unread {
+		if s.ID == "a" || s.ID == "c" {
+			t.Errorf("CentralUnread has %s, the seed or read", s.ID)
+		}
+	}
+	if len(unread) != 2 || unread[0].ID != "b" {
+		t.Errorf("CentralUnread = %+v, want b then d", unread)
+	}
+	if got := CentralUnread(g, nil, 1); len(got) != 1 {
+		t.Errorf("CentralUnread with n 1 gave %d", len(got))
+	}
+}
+
+func TestCoupling(t *testing.T) {
+	g := graph(t, "a>b", "a>c", "b>c", "d>b", "d>c")
+	ps := Coupling(g)
+	want := []Pair{
+		{A: "a", B: "d", Shared: 2, Similarity: 1},
+		{A: "a", B: "b", Shared: 1, Similarity: 1 / math.Sqrt(2)},
+		{A: "b", B: "d", Shared: 1, Similarity: 1 / math.Sqrt(2)},
+	}
+	if len(ps) != len(want) {
+		t.Fatalf("Coupling = %+v, want %+v", ps, want)
+	}
+	for i := range want {
+		if ps[i].A != want[i].A || ps[i].B != want[i].B || ps[i].Shared != want[i].Shared || !near(ps[i].Similarity, want[i].Similarity) {
+			t.Errorf("Coupling[%d] = %+v, want %+v", i, ps[i], want[i])
+		}
+	}
+}
+
+func TestCoCitation(t *testing.T) {
+	g := graph(t, "a>b", "a>c", "b>c", "d>b", "d>c")
+	ps := CoCitation(g)
+	if len(ps) != 1 {
+		t.Fatalf("CoCitation = %+v, want only b and c", ps)
+	}
+	p := ps[0]
+	if p.A != "b" || p.B != "c" || p.Shared != 2 || !near(p.Similarity, 2/math.Sqrt(6)) {
+		t.Errorf("CoCitation = %+v, want b and c cited together twice", p)
+	}
+}
+
+func TestSimilar(t *testing.T) {
+	ps := []Pair{{A: "a", B: "b", Shared: 3}, {A: "b", B: "c", Shared: 1}, {A: "a", B: "c", Shared: 2}}
+	got := Similar(ps, "b")
+	if len(got) != 2 || got[0].A != "b" || got[0].B != "a" || got[1].B != "c" {
+		t.Errorf("Similar(b) = %+v", got)
+	}
+}
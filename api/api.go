@@ -3,6 +3,7 @@ package api
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
@@ -12,10 +13,13 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/benjaminchristie/go-arxiv-tree/cache"
-	"github.com/benjaminchristie/go-arxiv-tree/comms"
+	"github.com/benjaminchristie/go-arxiv-tree/events"
+	"github.com/benjaminchristie/go-arxiv-tree/ledger"
 	ratelimiter "github.com/benjaminchristie/go-arxiv-tree/rate_limiter"
 	"github.com/jschaf/bibtex"
 	"github.com/jschaf/bibtex/ast"
@@ -35,14 +39,15 @@ type Cat struct {
 }
 
 type Entry struct {
-	Title     string   `xml:"title"`
-	ID        string   `xml:"id"`
-	Links     []Link   `xml:"link"`
-	Updated   string   `xml:"updated"`
-	Published string   `xml:"published"`
-	Summary   string   `xml:"summary"`
-	Author    []Author `xml:"author"`
-	Category  Cat      `xml:"category"`
+	Title      string   `xml:"title"`
+	ID         string   `xml:"id"`
+	Links      []Link   `xml:"link"`
+	Updated    string   `xml:"updated"`
+	Published  string   `xml:"published"`
+	Summary    string   `xml:"summary"`
+	Author     []Author `xml:"author"`
+	Category   Cat      `xml:"primary_category"`
+	Categories []Cat    `xml:"category"`
 }
 
 type Host struct {
@@ -59,20 +64,19 @@ type QueryRequest struct {
 	Cat        string // category to search
 }
 
-type NetData struct {
-	Message string
-	Size    int
-}
-
 var tarExtractRegexpHelper *regexp.Regexp
 
-const ARXIV_API = "https://export.arxiv.org/api"
+const API_HOST = "export.arxiv.org"
+const DOWNLOAD_HOST = "arxiv.org"
+const ARXIV_API = "https://" + API_HOST + "/api"
 
 var queryCache *cache.Cache
 var targzCache *cache.Cache
 var downlCache *cache.Cache
 
 var biber *bibtex.Biber
+var requestLedger *ledger.Ledger
+var logger = log.Logger("api")
 
 func init() {
 	var err error
@@ -86,11 +90,17 @@ func init() {
 	downlCache = &cache.Cache{}
 }
 
+// SetLedger makes every request count against l, and fail once l's budget
+// is used up. A nil ledger turns accounting off.
+func SetLedger(l *ledger.Ledger) {
+	requestLedger = l
+}
+
 func ParseXML(s string) []Entry {
 	host := Host{}
 	err := xml.Unmarshal([]byte(s), &host)
 	if err != nil {
-		log.Printf("Error unmarshalling field %v", err)
+		logger.Warn("could not unmarshal feed", log.Err(err))
 	}
 	return host.Entries
 }
@@ -108,6 +118,16 @@ func ReadBibtexFile(filename string) ([]bibtex.Entry, error) {
 	return entries, err
 }
 
+// BibtexField is the text of field in b without braces, or "" if b has
+// no such field
+func BibtexField(b bibtex.Entry, field bibtex.Field) string {
+	v, ok := b.Tags[field].(*ast.UnparsedText)
+	if !ok {
+		return ""
+	}
+	return strings.NewReplacer("{", "", "}", "").Replace(strings.TrimSpace(v.Value))
+}
+
 func QueryBibtexEntry(b bibtex.Entry) (string, string, error) {
 	author, ok := b.Tags[bibtex.FieldAuthor].(*ast.UnparsedText)
 	if !ok {
@@ -183,7 +203,76 @@ func parseQueryRequest(q QueryRequest) (string, error) {
 	}
 }
 
+// fetch performs a GET against u, waiting on the rate limiter configured for
+// u's host, and returns the status code and the full body. The request is
+// queued under the class stored in ctx, or class if there is none. banned,
+// if not nil, tells whether a 200 response is a soft ban.
+func fetch(ctx context.Context, u string, class ratelimiter.Class, banned func(body []byte) bool) (int, []byte, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return 0, nil, err
+	}
+	limiter := ratelimiter.ForHost(parsed.Host)
+	if limiter != nil {
+		err = limiter.Wait(ctx, ratelimiter.ClassFromContext(ctx, class))
+		if err != nil {
+			return 0, nil, err
+		}
+		defer limiter.Done()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if requestLedger != nil {
+		err = requestLedger.Record(parsed.Host)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	ratelimiter.CountRequest(parsed.Host)
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Warn("request failed", log.URL(u), log.Err(err))
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	logger.Debug("request", log.URL(u), "status", resp.StatusCode, log.Duration(time.Since(start)))
+	body, err := io.ReadAll(resp.Body)
+	if limiter != nil {
+		limiter.Observe(ratelimiter.Outcome{
+			Status:     resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Empty:      err == nil && resp.StatusCode == http.StatusOK && banned != nil && banned(body),
+		})
+	}
+	return resp.StatusCode, body, err
+}
+
+// Retry-After is either a number of seconds or an HTTP date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	secs, err := strconv.Atoi(v)
+	if err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	t, err := http.ParseTime(v)
+	if err == nil && t.After(time.Now()) {
+		return time.Until(t)
+	}
+	return 0
+}
+
 func Query(req QueryRequest) (string, error) {
+	return QueryContext(context.Background(), req)
+}
+
+// QueryContext is Query with a context. Queries are queued as
+// ratelimiter.Metadata unless ctx carries another class.
+func QueryContext(ctx context.Context, req QueryRequest) (string, error) {
 	var t any
 	s, err := parseQueryRequest(req)
 	if err != nil {
@@ -194,25 +283,37 @@ func Query(req QueryRequest) (string, error) {
 	if t != nil {
 		return t.(string), nil
 	}
-	ratelimiter.WaitIfEnabled()
-	resp, err := http.Get(req_url)
+	events.Emit(events.Query(s))
+	start := time.Now()
+	// an ID query always has a result unless arXiv is pushing back, while
+	// most title searches for references simply find nothing
+	var banned func([]byte) bool
+	if req.IDList != "" {
+		banned = func(b []byte) bool {
+			return len(ParseXML(string(b))) == 0
+		}
+	}
+	status, bodyBytes, err := fetch(ctx, req_url, ratelimiter.Metadata, banned)
 	if err != nil {
+		events.Emit(events.Completed(s, 0, 0, time.Since(start), err))
 		return "", err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusOK {
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return "", err
-		}
+	if status == http.StatusOK {
 		result := string(bodyBytes)
+		n := len(ParseXML(result))
+		events.Emit(events.Completed(s, n, len(bodyBytes), time.Since(start), nil))
+		if n == 0 { // maybe a soft ban, don't cache it
+			return result, nil
+		}
 		queryCache.Set(req_url, result)
 		return result, nil
 	}
+	err = errors.New(fmt.Sprintf("Status not ok for query: %s Code:%d", s, status))
+	events.Emit(events.Completed(s, 0, len(bodyBytes), time.Since(start), err))
 	return "", err
 }
 
-func ExtractTargz(infile, outdir string, comms ...comms.Comm) error {
+func ExtractTargz(infile, outdir string) error {
 	var err error
 	var r *os.File
 	var gzipStream *gzip.Reader
@@ -257,10 +358,6 @@ func ExtractTargz(infile, outdir string, comms ...comms.Comm) error {
 			if err != nil {
 				return err
 			}
-			fn := file.Name()
-			for _, c := range comms {
-				c.Send(fn)
-			}
 		default:
 			return errors.New(fmt.Sprintf("Unknown type in extractTargz: %v in %s", header.Typeflag, header.Name))
 		}
@@ -269,10 +366,30 @@ func ExtractTargz(infile, outdir string, comms ...comms.Comm) error {
 	return nil
 }
 
+// writeFile writes to a temporary file next to outfile and renames it into
+// place, so an interrupted download never leaves a truncated file behind
+func writeFile(outfile string, body []byte) error {
+	part := outfile + ".part"
+	err := os.WriteFile(part, body, 0644)
+	if err == nil {
+		err = os.Rename(part, outfile)
+	}
+	if err != nil {
+		os.Remove(part)
+	}
+	return err
+}
+
 // downloads tar.gz formatted source code
-func DownloadSource(id, outfile string, comms ...comms.Comm) error {
+func DownloadSource(id, outfile string) error {
+	return DownloadSourceContext(context.Background(), id, outfile)
+}
+
+// DownloadSourceContext is DownloadSource with a context. Downloads are
+// queued as ratelimiter.Metadata unless ctx carries another class.
+func DownloadSourceContext(ctx context.Context, id, outfile string) error {
 	var err error
-	var resp *http.Response
+	var status int
 	var body []byte
 
 	stored := downlCache.Get("SOURCE" + id + outfile)
@@ -287,40 +404,44 @@ func DownloadSource(id, outfile string, comms ...comms.Comm) error {
 			return err
 		}
 	}
-	ratelimiter.WaitIfEnabled()
-	resp, err = http.Get(fmt.Sprintf("https://arxiv.org/src/%s", id))
+	start := time.Now()
+	status, body, err = fetch(ctx, fmt.Sprintf("https://%s/src/%s", DOWNLOAD_HOST, id), ratelimiter.Metadata, nil)
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode == http.StatusOK {
-		body, err = io.ReadAll(resp.Body)
+	if status == http.StatusOK {
+		err = writeFile(outfile, body)
 		if err != nil {
 			return err
 		}
-		for _, c := range comms {
-			go c.Send(string(body)) // c.Send blocks
-		}
-		err = os.WriteFile(outfile, body, 0644)
-		if err != nil {
-			os.Remove(outfile)
-			return err
-		}
+		events.Emit(events.Source(id, len(body), time.Since(start)))
 	} else {
-		return errors.New(fmt.Sprintf("Status not ok for ID: %s Code:%d", id, resp.StatusCode))
+		return errors.New(fmt.Sprintf("Status not ok for ID: %s Code:%d", id, status))
 	}
 	downlCache.Set("SOURCE"+id+outfile, true)
 	return nil
 }
 
-func DownloadPDF(id, outfile string, comms ...comms.Comm) error {
+func DownloadPDF(id, outfile string) error {
+	return DownloadPDFContext(context.Background(), id, outfile)
+}
+
+// DownloadPDFContext is DownloadPDF with a context. Downloads are queued as
+// ratelimiter.Bulk unless ctx carries another class.
+func DownloadPDFContext(ctx context.Context, id, outfile string) error {
 	var err error
-	var resp *http.Response
+	var status int
 	var body []byte
 
 	stored := downlCache.Get("PDF" + id + outfile)
 	if stored != nil {
 		return nil
 	}
+	// writeFile renames into place, so an existing file is complete
+	if _, err = os.Stat(outfile); err == nil {
+		downlCache.Set("PDF"+id+outfile, true)
+		return nil
+	}
 	idx := strings.LastIndex(outfile, "/")
 	if idx != -1 {
 		err = os.MkdirAll(outfile[0:idx], 0755)
@@ -328,26 +449,19 @@ func DownloadPDF(id, outfile string, comms ...comms.Comm) error {
 			return err
 		}
 	}
-	ratelimiter.WaitIfEnabled()
-	resp, err = http.Get(fmt.Sprintf("https://arxiv.org/pdf/%s", id))
+	start := time.Now()
+	status, body, err = fetch(ctx, fmt.Sprintf("https://%s/pdf/%s", DOWNLOAD_HOST, id), ratelimiter.Bulk, nil)
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode == http.StatusOK {
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-		for _, c := range comms {
-			go c.Send(string(body)) // c.Send blocks
-		}
-		err = os.WriteFile(outfile, body, 0644)
+	if status == http.StatusOK {
+		err = writeFile(outfile, body)
 		if err != nil {
-			os.Remove(outfile)
 			return err
 		}
+		events.Emit(events.PDF(id, outfile, len(body), time.Since(start)))
 	} else {
-		return errors.New(fmt.Sprintf("Status not ok for ID: %s Code:%d", id, resp.StatusCode))
+		return errors.New(fmt.Sprintf("Status not ok for ID: %s Code:%d", id, status))
 	}
 	downlCache.Set("PDF"+id+outfile, true)
 	return nil
@@ -0,0 +1,75 @@
+package arxivlogger
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// attribute keys shared by every subsystem, so logs can be filtered on them
+const (
+	KeySubsystem = "subsystem"
+	KeyID        = "arxiv_id"
+	KeyDepth     = "depth"
+	KeyURL       = "url"
+	KeyDuration  = "duration"
+	KeyError     = "error"
+)
+
+func ID(id string) slog.Attr             { return slog.String(KeyID, id) }
+func Depth(d int) slog.Attr              { return slog.Int(KeyDepth, d) }
+func URL(u string) slog.Attr             { return slog.String(KeyURL, u) }
+func Duration(d time.Duration) slog.Attr { return slog.Duration(KeyDuration, d) }
+func Err(err error) slog.Attr            { return slog.Any(KeyError, err) }
+
+// root is replaced by Setup. Loggers handed out before then, typically to
+// package level variables, still write through whatever root is current.
+var root atomic.Pointer[slog.Handler]
+
+// setRoot installs h, teed with the ring buffer
+func setRoot(h slog.Handler) {
+	var t slog.Handler = teeHandler{h, &ringHandler{ring: ring, source: "std"}}
+	root.Store(&t)
+}
+
+// handler defers to the current root, replaying any WithAttrs and WithGroup
+// calls made on it
+type handler struct {
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) current() slog.Handler {
+	c := *root.Load()
+	for _, op := range h.ops {
+		c = op(c)
+	}
+	return c
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &handler{ops: append(ops, op)}
+}
+
+func (h *handler) Enabled(ctx context.Context, l slog.Level) bool {
+	return (*root.Load()).Enabled(ctx, l)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	return h.current().Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(c slog.Handler) slog.Handler { return c.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(c slog.Handler) slog.Handler { return c.WithGroup(name) })
+}
+
+// Logger returns the logger for a subsystem such as "api" or "tree"
+func Logger(subsystem string) *slog.Logger {
+	return slog.New(&handler{}).With(KeySubsystem, subsystem)
+}
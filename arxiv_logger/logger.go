@@ -1,75 +1,123 @@
 package arxivlogger
 
 import (
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
 )
 
+type Config struct {
+	Level    slog.Level
+	Format   string // "text" or "json"
+	Stdout   bool
+	Filename string // empty for no log file
+	RingSize int    // records kept in memory, 0 keeps the current ring
+}
+
 var f *os.File
-var enabled bool
+var level slog.LevelVar
+var app *slog.Logger
 
 func init() {
-	enabled = false
+	ring = MakeRing(DefaultRingSize)
+	// nothing but the ring is written to until Setup or Initialize is called
+	setRoot(slog.NewTextHandler(io.Discard, nil))
+	app = Logger("app")
 }
 
-func Initialize(debugEnable bool, toStdout bool, filename ...string) {
-	enabled = debugEnable
-	if len(filename) == 0 && toStdout {
-		log.SetOutput(os.Stdout)
-	} else {
-		var err error
-		f, err = os.OpenFile(filename[0], os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(s))
+	return l, err
+}
+
+func Setup(c Config) error {
+	var writers []io.Writer
+	format := strings.ToLower(c.Format)
+	if format != "" && format != "text" && format != "json" {
+		return errors.New(fmt.Sprintf("Unknown log format %s", c.Format))
+	}
+	if c.Filename != "" {
+		fh, err := os.OpenFile(c.Filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 		if err != nil {
-			log.Fatalf("Could not open file for logging: %s", filename[0])
-			return
+			return fmt.Errorf("could not open file for logging: %w", err)
 		}
-		c := make(chan os.Signal)
-		signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
-		go func() {
-			<-c
-			log.Printf("Caught shutdown, closing log file")
-			f.Close()
-			os.Exit(1)
+		writers = append(writers, fh)
+		defer func() {
+			// only swap files once the new handler is installed
+			Close()
+			f = fh
 		}()
-		if toStdout {
-			multi := io.MultiWriter(f, os.Stdout)
-			log.SetOutput(multi)
-		} else {
-			log.SetOutput(f)
-		}
 	}
+	if c.Stdout {
+		writers = append(writers, os.Stdout)
+	}
+	var w io.Writer = io.Discard
+	if len(writers) != 0 {
+		w = io.MultiWriter(writers...)
+	}
+	if c.RingSize > 0 {
+		ring = MakeRing(c.RingSize)
+	}
+	level.Set(c.Level)
+	opts := &slog.HandlerOptions{Level: &level}
+	if format == "json" {
+		setRoot(slog.NewJSONHandler(w, opts))
+	} else {
+		setRoot(slog.NewTextHandler(w, opts))
+	}
+	// route the standard library logger through the same handler
+	slog.SetDefault(slog.New(&handler{}))
+	return nil
 }
 
-func Fatalf(s string, v ...any) {
-	if enabled {
-		log.Printf(s, v...)
+// Initialize is the original boolean interface to Setup. With debugEnable
+// false only warnings and errors are written.
+func Initialize(debugEnable bool, toStdout bool, filename ...string) error {
+	c := Config{
+		Level:  slog.LevelWarn,
+		Stdout: toStdout,
+	}
+	if debugEnable {
+		c.Level = slog.LevelDebug
+	}
+	if len(filename) != 0 {
+		c.Filename = filename[0]
 	}
-	os.Exit(1)
+	return Setup(c)
 }
 
-func Fatal(v ...any) {
-	if enabled {
-		log.Print(v...)
+// Close closes the log file, if any. Anything logged afterwards is lost
+// unless Setup is called again.
+func Close() error {
+	if f == nil {
+		return nil
 	}
-	os.Exit(1)
+	err := f.Sync()
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	f = nil
+	return err
+}
+
+func SetLevel(l slog.Level) {
+	level.Set(l)
 }
 
 func Printf(s string, v ...any) {
-	if enabled {
-		log.Printf(s, v...)
-	}
+	app.Info(fmt.Sprintf(s, v...))
 }
 
 func Print(v ...any) {
-	if enabled {
-		log.Print(v...)
-	}
+	app.Info(fmt.Sprint(v...))
 }
+
 func Println(v ...any) {
-	if enabled {
-		log.Println(v...)
-	}
+	app.Info(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
 }
@@ -0,0 +1,208 @@
+package arxivlogger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is a log record as kept by a Ring
+type Record struct {
+	Seq     uint64
+	Time    time.Time
+	Level   slog.Level
+	Source  string // the subsystem that logged it
+	Message string
+	Attrs   []slog.Attr
+}
+
+func (r Record) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s: %s", r.Level, r.Source, r.Message)
+	for _, a := range r.Attrs {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+	}
+	return b.String()
+}
+
+// Ring keeps the most recent records in memory and passes new ones on to
+// any number of subscribers. Subscribers that fall behind miss records
+// rather than slowing down whoever is logging.
+type Ring struct {
+	Level       slog.LevelVar
+	lock        sync.Mutex
+	records     []Record
+	next        int // where the next record goes once records is full
+	seq         uint64
+	subscribers map[chan Record]bool
+}
+
+const DefaultRingSize = 1000
+
+var ring *Ring
+
+func MakeRing(size int) *Ring {
+	r := &Ring{
+		records:     make([]Record, 0, max(size, 1)),
+		subscribers: make(map[chan Record]bool),
+	}
+	r.Level.Set(slog.LevelInfo)
+	return r
+}
+
+func (r *Ring) add(rec Record) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.seq++
+	rec.Seq = r.seq
+	if len(r.records) < cap(r.records) {
+		r.records = append(r.records, rec)
+	} else {
+		r.records[r.next] = rec
+		r.next = (r.next + 1) % len(r.records)
+	}
+	for c := range r.subscribers {
+		select {
+		case c <- rec:
+		default:
+		}
+	}
+}
+
+// must hold r.lock
+func (r *Ring) snapshot() []Record {
+	s := make([]Record, 0, len(r.records))
+	s = append(s, r.records[r.next:]...)
+	return append(s, r.records[:r.next]...)
+}
+
+// Records returns the buffered records, oldest first
+func (r *Ring) Records() []Record {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.snapshot()
+}
+
+// Follow returns the buffered records along with a channel of every record
+// logged after them, and a function that ends the subscription.
+func (r *Ring) Follow(buffer int) ([]Record, <-chan Record, func()) {
+	c := make(chan Record, max(buffer, 1))
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.subscribers[c] = true
+	return r.snapshot(), c, func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if r.subscribers[c] {
+			delete(r.subscribers, c)
+			close(c)
+		}
+	}
+}
+
+// Recent is the ring every logger in the process writes to
+func Recent() *Ring {
+	return ring
+}
+
+// ringHandler is the slog side of a Ring
+type ringHandler struct {
+	ring   *Ring
+	source string
+	attrs  []slog.Attr
+	group  string
+}
+
+func (h *ringHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.ring.Level.Level()
+}
+
+func (h *ringHandler) Handle(_ context.Context, r slog.Record) error {
+	rec := Record{
+		Time:    r.Time,
+		Level:   r.Level,
+		Source:  h.source,
+		Message: r.Message,
+		Attrs:   append([]slog.Attr{}, h.attrs...),
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		rec.Attrs = append(rec.Attrs, h.qualify(a))
+		return true
+	})
+	h.ring.add(rec)
+	return nil
+}
+
+func (h *ringHandler) qualify(a slog.Attr) slog.Attr {
+	if h.group != "" {
+		a.Key = h.group + "." + a.Key
+	}
+	return a
+}
+
+func (h *ringHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	n := *h
+	n.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		if a.Key == KeySubsystem && h.group == "" {
+			n.source = a.Value.String()
+			continue
+		}
+		n.attrs = append(n.attrs, h.qualify(a))
+	}
+	return &n
+}
+
+func (h *ringHandler) WithGroup(name string) slog.Handler {
+	n := *h
+	if n.group == "" {
+		n.group = name
+	} else {
+		n.group += "." + name
+	}
+	return &n
+}
+
+// teeHandler sends records to every handler that wants them
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			e := h.Handle(ctx, r.Clone())
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	n := make(teeHandler, len(t))
+	for i, h := range t {
+		n[i] = h.WithAttrs(attrs)
+	}
+	return n
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	n := make(teeHandler, len(t))
+	for i, h := range t {
+		n[i] = h.WithGroup(name)
+	}
+	return n
+}
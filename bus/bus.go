@@ -0,0 +1,150 @@
+package bus
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Policy decides what Publish does when a subscriber's buffer is full
+type Policy int
+
+const (
+	Block      Policy = iota // wait until the subscriber catches up or leaves
+	DropOldest               // make room by discarding the oldest buffered value
+	DropNewest               // discard the value being published
+)
+
+// Topic fans values of type T out to every subscriber. The zero value is
+// not usable, use MakeTopic.
+type Topic[T any] struct {
+	lock    sync.RWMutex
+	subs    map[*Subscription[T]]bool
+	closed  bool
+	closing atomic.Bool // set before Close lets go of blocked publishers
+}
+
+type Subscription[T any] struct {
+	C       <-chan T // closed on Unsubscribe or when the topic is closed
+	c       chan T
+	policy  Policy
+	topic   *Topic[T]
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+func MakeTopic[T any]() *Topic[T] {
+	return &Topic[T]{
+		subs: make(map[*Subscription[T]]bool),
+	}
+}
+
+// Subscribe starts delivering values published from now on. A buffer of 0
+// makes an unbuffered subscription, which only makes sense with Block.
+func (t *Topic[T]) Subscribe(buffer int, p Policy) *Subscription[T] {
+	if p != Block {
+		buffer = max(buffer, 1)
+	}
+	c := make(chan T, buffer)
+	s := &Subscription[T]{
+		C:      c,
+		c:      c,
+		policy: p,
+		topic:  t,
+		done:   make(chan struct{}),
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed || t.closing.Load() {
+		s.once.Do(func() { close(s.done) })
+		close(c)
+		return s
+	}
+	t.subs[s] = true
+	return s
+}
+
+// Publish delivers v to every subscriber according to its policy. Publishing
+// to a closed topic does nothing.
+func (t *Topic[T]) Publish(v T) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if t.closed {
+		return
+	}
+	for s := range t.subs {
+		s.deliver(v)
+	}
+}
+
+// must hold s.topic.lock for reading
+func (s *Subscription[T]) deliver(v T) {
+	switch s.policy {
+	case Block:
+		select {
+		case s.c <- v:
+		case <-s.done:
+		}
+	case DropNewest:
+		select {
+		case s.c <- v:
+		default:
+			s.dropped.Add(1)
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.c <- v:
+				return
+			default:
+			}
+			select {
+			case <-s.c:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	}
+}
+
+// Close ends every subscription. Receivers see their channel closed once
+// they have drained it.
+func (t *Topic[T]) Close() {
+	// refuse new subscribers first, one joining after the loop below would
+	// never be let go and could keep a publisher holding the read lock
+	t.closing.Store(true)
+	t.lock.RLock()
+	for s := range t.subs {
+		s.once.Do(func() { close(s.done) })
+	}
+	t.lock.RUnlock()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	for s := range t.subs {
+		close(s.c)
+		delete(t.subs, s)
+	}
+}
+
+// Unsubscribe stops delivery and closes s.C. It is safe to call more than
+// once, and while a blocked Publish is waiting on s.
+func (s *Subscription[T]) Unsubscribe() {
+	// unblock any publisher waiting on us before taking the write lock
+	s.once.Do(func() { close(s.done) })
+	t := s.topic
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.subs[s] {
+		delete(t.subs, s)
+		close(s.c)
+	}
+}
+
+// Dropped counts the values this subscriber missed under a drop policy
+func (s *Subscription[T]) Dropped() uint64 {
+	return s.dropped.Load()
+}
@@ -0,0 +1,167 @@
+package bus
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func drain[T any](c <-chan T) []T {
+	var got []T
+	for v := range c {
+		got = append(got, v)
+	}
+	return got
+}
+
+func TestFanOut(t *testing.T) {
+	topic := MakeTopic[int]()
+	a := topic.Subscribe(3, Block)
+	b := topic.Subscribe(3, Block)
+	for i := 1; i <= 3; i++ {
+		topic.Publish(i)
+	}
+	topic.Close()
+	for _, s := range []*Subscription[int]{a, b} {
+		if got := drain(s.C); !slices.Equal(got, []int{1, 2, 3}) {
+			t.Errorf("subscriber got %v, want [1 2 3]", got)
+		}
+	}
+}
+
+func TestSubscribeSeesOnlyLaterValues(t *testing.T) {
+	topic := MakeTopic[int]()
+	topic.Publish(1)
+	s := topic.Subscribe(1, Block)
+	topic.Publish(2)
+	topic.Close()
+	if got := drain(s.C); !slices.Equal(got, []int{2}) {
+		t.Errorf("got %v, want [2]", got)
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	topic := MakeTopic[int]()
+	s := topic.Subscribe(2, DropOldest)
+	for i := 1; i <= 5; i++ {
+		topic.Publish(i)
+	}
+	topic.Close()
+	if got := drain(s.C); !slices.Equal(got, []int{4, 5}) {
+		t.Errorf("got %v, want the newest two, [4 5]", got)
+	}
+	if s.Dropped() != 3 {
+		t.Errorf("Dropped() = %d, want 3", s.Dropped())
+	}
+}
+
+func TestDropNewest(t *testing.T) {
+	topic := MakeTopic[int]()
+	s := topic.Subscribe(2, DropNewest)
+	for i := 1; i <= 5; i++ {
+		topic.Publish(i)
+	}
+	topic.Close()
+	if got := drain(s.C); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("got %v, want the oldest two, [1 2]", got)
+	}
+	if s.Dropped() != 3 {
+		t.Errorf("Dropped() = %d, want 3", s.Dropped())
+	}
+}
+
+func TestDropPolicyNeedsBuffer(t *testing.T) {
+	topic := MakeTopic[int]()
+	s := topic.Subscribe(0, DropNewest)
+	topic.Publish(1) // must not block or drop
+	topic.Close()
+	if got := drain(s.C); !slices.Equal(got, []int{1}) {
+		t.Errorf("got %v, want [1]", got)
+	}
+}
+
+func TestBlockWaitsForSubscriber(t *testing.T) {
+	topic := MakeTopic[int]()
+	s := topic.Subscribe(0, Block)
+	published := make(chan bool)
+	go func() {
+		topic.Publish(1)
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("Publish returned before the value was received")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if v := <-s.C; v != 1 {
+		t.Errorf("received %d, want 1", v)
+	}
+	<-published
+}
+
+func TestUnsubscribeUnblocksPublish(t *testing.T) {
+	topic := MakeTopic[int]()
+	s := topic.Subscribe(0, Block)
+	published := make(chan bool)
+	go func() {
+		topic.Publish(1)
+		close(published)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Unsubscribe()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Publish still blocked after Unsubscribe")
+	}
+	if _, ok := <-s.C; ok {
+		t.Error("C is still open after Unsubscribe")
+	}
+	s.Unsubscribe() // a second call is harmless
+	topic.Publish(2)
+}
+
+func TestClosedTopic(t *testing.T) {
+	topic := MakeTopic[int]()
+	topic.Close()
+	topic.Close()
+	topic.Publish(1)
+	s := topic.Subscribe(1, Block)
+	if _, ok := <-s.C; ok {
+		t.Error("subscribing to a closed topic gave an open channel")
+	}
+	s.Unsubscribe()
+}
+
+func TestCloseWhileSubscribing(t *testing.T) {
+	for range 100 {
+		topic := MakeTopic[int]()
+		subs := make(chan *Subscription[int], 10)
+		go func() {
+			defer close(subs)
+			for range 10 {
+				subs <- topic.Subscribe(0, Block)
+			}
+		}()
+		go topic.Publish(1)
+		closed := make(chan bool)
+		go func() {
+			topic.Close()
+			close(closed)
+		}()
+		for s := range subs {
+			// nobody reads the value a blocked Publish may have sent,
+			// Close has to end the subscription anyway
+			select {
+			case <-s.done:
+			case <-time.After(time.Second):
+				t.Fatal("a subscription outlived Close")
+			}
+		}
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("Close did not return")
+		}
+	}
+}
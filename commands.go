@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/benjaminchristie/go-arxiv-tree/analytics"
+	"github.com/benjaminchristie/go-arxiv-tree/api"
+	"github.com/benjaminchristie/go-arxiv-tree/export"
+	"github.com/benjaminchristie/go-arxiv-tree/ledger"
+	ratelimiter "github.com/benjaminchristie/go-arxiv-tree/rate_limiter"
+	"github.com/benjaminchristie/go-arxiv-tree/shutdown"
+	"github.com/benjaminchristie/go-arxiv-tree/tree"
+)
+
+// subcommands are named by the first argument, e.g. `go-arxiv-tree usage`,
+// and take their own flags
+var commands = map[string]func(args []string) error{
+	"usage":  usageCommand,
+	"report": reportCommand,
+	"diff":   diffCommand,
+	"query":  queryCommand,
+	"path":   pathCommand,
+}
+
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return false
+	}
+	err := cmd(args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func usageCommand(args []string) error {
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	ledgerPtr := fs.String("ledger", ledger.DefaultPath(), "request ledger file")
+	daysPtr := fs.Int("days", 7, "number of days to report")
+	fs.Parse(args)
+
+	l := ledger.MakeLedger(*ledgerPtr, ledger.Budget{})
+	rows, err := l.Usage(*daysPtr)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "DAY\tHOST\tREQUESTS\n")
+	total := 0
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", r.Day, r.Host, r.Count)
+		total += r.Count
+	}
+	fmt.Fprintf(w, "total\t\t%d\n", total)
+	return w.Flush()
+}
+
+// reportCommand ranks the papers in a saved graph, e.g.
+// `go-arxiv-tree report -top 20 -read read.txt arxiv-tree.json`
+func reportCommand(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	topPtr := fs.Int("top", 10, "rows per section, 0 for all")
+	sortPtr := fs.String("sort", "pagerank", "order of the ranking: "+strings.Join(analytics.Columns, ", "))
+	readPtr := fs.String("read", "", "file listing the arXiv IDs already read, one per line")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s report [flags] snapshot.json\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("want one snapshot file, got %d", fs.NArg())
+	}
+	g, err := tree.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	read := map[string]bool{}
+	if *readPtr != "" {
+		read, err = analytics.ReadList(*readPtr)
+		if err != nil {
+			return err
+		}
+	}
+	scores := analytics.Scores(g)
+	err = analytics.SortBy(scores, *sortPtr)
+	if err != nil {
+		return err
+	}
+	titles := map[string]string{}
+	for _, s := range scores {
+		titles[s.ID] = s.Title
+	}
+	top := func(n int) int {
+		if *topPtr > 0 {
+			return min(n, *topPtr)
+		}
+		return n
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Papers by %s (%d in graph)\n", *sortPtr, len(scores))
+	printScores(w, scores[:top(len(scores))], read)
+
+	unread := analytics.CentralUnread(g, read, *topPtr)
+	fmt.Fprintf(w, "\nMost central unread papers\n")
+	printScores(w, unread, read)
+
+	for _, section := range []struct {
+		name  string
+		pairs []analytics.Pair
+	}{
+		{"Most co-cited pairs", analytics.CoCitation(g)},
+		{"Most coupled pairs", analytics.Coupling(g)},
+	} {
+		fmt.Fprintf(w, "\n%s\n", section.name)
+		fmt.Fprintf(w, "SHARED\tSIMILARITY\tPAPER\tPAPER\n")
+		for _, p := range section.pairs[:top(len(section.pairs))] {
+			fmt.Fprintf(w, "%d\t%.3f\t%s\t%s\n", p.Shared, p.Similarity, short(titles[p.A], 40), short(titles[p.B], 40))
+		}
+	}
+	return w.Flush()
+}
+
+// diffCommand compares two saved graphs, e.g.
+// `go-arxiv-tree diff -format dot -o changes.dot march.json april.json`,
+// or the references of two versions of a paper, e.g.
+// `go-arxiv-tree diff 1706.03762v1 1706.03762v5`
+func diffCommand(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	formatPtr := fs.String("format", "text", "text, json or dot")
+	outPtr := fs.String("o", "", "write to this file instead of stdout")
+	maxRefsPtr := fs.Int("max-refs", 0, "with versions, resolve at most this many references of each")
+	safePtr := fs.Bool("safe", false, "with versions, enable safe mode (rate-limited)")
+	ledgerPtr := fs.String("ledger", ledger.DefaultPath(), "with versions, request ledger shared by every run on this machine")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s diff [flags] old.json new.json\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s diff [flags] 1706.03762v1 1706.03762v5\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("want two snapshot files or paper versions, got %d", fs.NArg())
+	}
+	var from, to *tree.Graph
+	var err error
+	if id, v, ok := tree.SplitVersion(fs.Arg(0)); ok {
+		// the references of two versions of one paper
+		id2, v2, ok := tree.SplitVersion(fs.Arg(1))
+		if !ok || id2 != id {
+			return fmt.Errorf("want two versions of %s, like %sv1 %sv2", id, id, id)
+		}
+		api.SetLedger(ledger.MakeLedger(*ledgerPtr, ledger.Budget{}))
+		if *safePtr {
+			ratelimiter.Enable()
+		}
+		ctx := ratelimiter.WithClass(context.Background(), ratelimiter.Interactive)
+		from, err = tree.VersionGraph(ctx, id, v, *maxRefsPtr)
+		if err != nil {
+			return err
+		}
+		to, err = tree.VersionGraph(ctx, id, v2, *maxRefsPtr)
+		if err != nil {
+			return err
+		}
+	} else {
+		from, err = tree.Load(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		to, err = tree.Load(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+	}
+	var write func(w io.Writer) error
+	switch *formatPtr {
+	case "text":
+		write = tree.Compare(from, to).WriteText
+	case "json":
+		write = tree.Compare(from, to).WriteJSON
+	case "dot":
+		write = func(w io.Writer) error {
+			return tree.WriteDiffDOT(w, from, to)
+		}
+	default:
+		return fmt.Errorf("unknown format %q, want text, json or dot", *formatPtr)
+	}
+	if *outPtr == "" {
+		return write(os.Stdout)
+	}
+	file, err := os.Create(*outPtr)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// queryCommand searches a saved graph, e.g.
+// `go-arxiv-tree query arxiv-tree.json 'author:vaswani year:2017..'`
+func queryCommand(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	treePtr := fs.Bool("tree", false, "print the tree down to the matches instead of a list")
+	idsPtr := fs.Bool("ids", false, "print only the IDs of the matches")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s query [flags] snapshot.json query...\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Queries are terms like title:word author:name id:1706.03762 year:2015..2020 cat:cs.*\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errors.New("want a snapshot file and a query")
+	}
+	g, err := tree.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	q, err := tree.ParseQuery(strings.Join(fs.Args()[1:], " "))
+	if err != nil {
+		return err
+	}
+	match := q.Matcher()
+	var found []*tree.ArxivTree
+	for _, root := range g.Trees() {
+		found = append(found, root.FindAll(match)...)
+	}
+	switch {
+	case *treePtr:
+		for _, root := range g.Trees() {
+			t := root.Filter(match)
+			if t == nil {
+				continue
+			}
+			err = t.Walk(func(n *tree.ArxivTree, path []*tree.ArxivTree) error {
+				mark := " "
+				if match(n) {
+					mark = "*"
+				}
+				fmt.Printf("%s %s%s  %.70s\n", mark, strings.Repeat("  ", len(path)-1), n.Paper.ID, n.Paper.Title)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	case *idsPtr:
+		for _, n := range found {
+			fmt.Println(n.Paper.ID)
+		}
+	default:
+		for _, n := range found {
+			var via []string
+			for _, p := range n.Path()[:n.Depth()] {
+				via = append(via, p.Paper.ID)
+			}
+			fmt.Printf("%s  depth %d  %.70s\n", n.Paper.ID, n.Depth(), n.Paper.Title)
+			if len(via) > 0 {
+				fmt.Printf("    via %s\n", strings.Join(via, " > "))
+			}
+		}
+		fmt.Printf("%d of %d papers match\n", len(found), g.Len())
+	}
+	return nil
+}
+
+// pathCommand shows how two papers in a saved graph connect, e.g.
+// `go-arxiv-tree path arxiv-tree.json 1706.03762 1409.0473`, or with
+// -crawl crawls outward from both until they meet
+func pathCommand(args []string) error {
+	fs := flag.NewFlagSet("path", flag.ExitOnError)
+	allPtr := fs.Bool("all", false, "list every path up to -max-len instead of one shortest path")
+	maxLenPtr := fs.Int("max-len", 0, fmt.Sprintf("most citations on a path, 0 for no limit, or %d with -all", tree.DefaultMaxPathLength))
+	limitPtr := fs.Int("limit", 20, "most paths -all lists, 0 for all of them")
+	undirectedPtr := fs.Bool("undirected", false, "also step from a paper to the papers citing it, always on with -crawl")
+	drawPtr := fs.String("viz-out", "", "draw the graph with the paths highlighted, as SVG if it ends in .svg and as DOT otherwise")
+	crawlPtr := fs.Bool("crawl", false, "crawl outward from both papers, given as IDs or titles, until they meet")
+	depthPtr := fs.Int("depth", 3, "with -crawl, most levels to crawl from each paper")
+	maxRefsPtr := fs.Int("max-refs", 0, "with -crawl, follow at most this many references per paper")
+	maxNodesPtr := fs.Int("max-nodes", 0, "with -crawl, stop once this many papers are in the graph")
+	safePtr := fs.Bool("safe", false, "with -crawl, enable safe mode (rate-limited)")
+	ledgerPtr := fs.String("ledger", ledger.DefaultPath(), "with -crawl, request ledger shared by every run on this machine")
+	savePtr := fs.String("save", "", "with -crawl, write the crawled graph to this snapshot file")
+	drainPtr := fs.Duration("drain", 10*time.Second, "with -crawl, how long to wait for in-flight requests on interrupt")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s path [flags] snapshot.json from to\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s path -crawl [flags] from to\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	o := tree.PathOptions{
+		MaxLength:  *maxLenPtr,
+		Undirected: *undirectedPtr || *crawlPtr,
+		Limit:      *limitPtr,
+	}
+
+	var g *tree.Graph
+	var from, to string
+	var err error
+	if *crawlPtr {
+		if fs.NArg() != 2 {
+			fs.Usage()
+			return errors.New("want two papers")
+		}
+		api.SetLedger(ledger.MakeLedger(*ledgerPtr, ledger.Budget{}))
+		if *safePtr {
+			ratelimiter.Enable()
+		}
+		c := tree.CrawlConfig{
+			Depth:    *depthPtr,
+			MaxRefs:  *maxRefsPtr,
+			MaxNodes: *maxNodesPtr,
+		}
+		// SIGINT stops the crawl, and the papers crawled so far are saved
+		coord := shutdown.MakeCoordinator(*drainPtr)
+		coord.Listen()
+		coord.Run(func(ctx context.Context) error {
+			ctx = ratelimiter.WithClass(ctx, ratelimiter.Interactive)
+			// both ends have to be found, in the order given
+			var ends [2]tree.Paper
+			for i, arg := range fs.Args() {
+				err = tree.MakeInfoFromQueryContext(ctx, &ends[i], tree.SeedQuery(arg), true)
+				if err != nil {
+					err = fmt.Errorf("could not find %q: %w", arg, err)
+					return err
+				}
+			}
+			from, to = tree.CanonicalID(ends[0].ID), tree.CanonicalID(ends[1].ID)
+			if from == to {
+				err = fmt.Errorf("%q and %q are both %s", fs.Arg(0), fs.Arg(1), from)
+				return err
+			}
+			g = tree.MakeGraph(ends[0], ends[1])
+			_, err = tree.Meet(ctx, g, from, to, c)
+			if errors.Is(err, tree.ErrNoPath) {
+				return nil
+			}
+			return err
+		})
+		if g == nil {
+			return err
+		}
+		fmt.Printf("Crawled %d papers from %s and %s\n", g.Len(), from, to)
+		if *savePtr != "" {
+			if err := tree.Save(g, *savePtr); err != nil {
+				return err
+			}
+		}
+		if err != nil && !errors.Is(err, tree.ErrNoPath) {
+			return err
+		}
+	} else {
+		if fs.NArg() != 3 {
+			fs.Usage()
+			return errors.New("want a snapshot file and two paper IDs")
+		}
+		g, err = tree.Load(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		from, to = fs.Arg(1), fs.Arg(2)
+	}
+
+	var paths []tree.Path
+	if *allPtr {
+		paths, err = g.AllPaths(from, to, o)
+	} else {
+		var p tree.Path
+		p, err = g.ShortestPath(from, to, o)
+		paths = []tree.Path{p}
+	}
+	if errors.Is(err, tree.ErrNoPath) && !o.Undirected {
+		return fmt.Errorf("%w, try -undirected", err)
+	}
+	if err != nil {
+		return err
+	}
+	for i, p := range paths {
+		if len(paths) > 1 {
+			fmt.Printf("Path %d, ", i+1)
+		}
+		fmt.Printf("%d citations:\n", p.Len())
+		printPath(g, p)
+	}
+	if *drawPtr != "" {
+		d := tree.DefaultDOTOptions
+		d.Paths = paths
+		return export.Visualize(g, *drawPtr, d)
+	}
+	return nil
+}
+
+// printPath lists the papers on p, marking each step -> where the paper
+// before cites the next and <- where it is cited by it
+func printPath(g *tree.Graph, p tree.Path) {
+	steps := g.Steps(p)
+	for i, id := range p {
+		n, _ := g.Node(id)
+		mark := ""
+		if i > 0 {
+			mark = "-> "
+			if steps[i-1].From == id {
+				mark = "<- "
+			}
+		}
+		fmt.Printf("  %3s%-12s %s\n", mark, id, short(n.Info.Title, 70))
+	}
+}
+
+func printScores(w io.Writer, scores []analytics.Score, read map[string]bool) {
+	fmt.Fprintf(w, "RANK\tPAGERANK\tCITED\tCITES\tYEAR\tDEPTH\tID\tTITLE\n")
+	for i, s := range scores {
+		title := short(s.Title, 60)
+		if read[s.ID] {
+			title += " (read)"
+		}
+		fmt.Fprintf(w, "%d\t%.4f\t%d\t%d\t%d\t%d\t%s\t%s\n", i+1, s.PageRank, s.InDegree, s.OutDegree, s.Year, s.Depth, s.ID, title)
+	}
+}
+
+// short cuts s to n runes
+func short(s string, n int) string {
+	r := []rune(strings.Join(strings.Fields(s), " "))
+	if len(r) <= n {
+		return string(r)
+	}
+	return string(r[:n-1]) + "…"
+}
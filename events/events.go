@@ -0,0 +1,195 @@
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/benjaminchristie/go-arxiv-tree/bus"
+)
+
+// Event is implemented by every event below. Consumers type switch on it.
+type Event interface {
+	When() time.Time
+	String() string
+}
+
+// At is embedded in every event
+type At struct {
+	Time time.Time
+}
+
+func (a At) When() time.Time { return a.Time }
+
+func now() At { return At{Time: time.Now()} }
+
+// QueryIssued is emitted before a search is sent to the arXiv API
+type QueryIssued struct {
+	At
+	Query string
+}
+
+type QueryCompleted struct {
+	At
+	Query    string
+	Results  int
+	Size     int
+	Duration time.Duration
+	Err      error
+}
+
+type SourceDownloaded struct {
+	At
+	ID       string
+	Size     int
+	Duration time.Duration
+}
+
+// ReferencesParsed is emitted once a paper's bibliography has been read
+type ReferencesParsed struct {
+	At
+	ID       string
+	Count    int
+	Duration time.Duration
+}
+
+// ReferenceResolved is emitted when a bibliography entry of ParentID has
+// been matched to an arXiv ID
+type ReferenceResolved struct {
+	At
+	ParentID string
+	ID       string
+	Title    string
+	Duration time.Duration
+}
+
+type NodeAdded struct {
+	At
+	ID       string
+	ParentID string // empty for a root
+	Title    string
+	Depth    int
+}
+
+type PDFSaved struct {
+	At
+	ID       string
+	Path     string
+	Size     int
+	Duration time.Duration
+}
+
+// NodeFailed is emitted when a node could not be resolved or expanded
+type NodeFailed struct {
+	At
+	ID    string
+	Title string
+	Depth int    // -1 when not known
+	Stage string // "resolve", "references" or "pdf"
+	Err   error
+}
+
+// NodeFiltered is emitted when a crawl filter keeps a reference out of the
+// graph, or keeps a paper from being expanded
+type NodeFiltered struct {
+	At
+	ID     string // empty when filtered before lookup
+	Title  string
+	Depth  int
+	Stage  string // "resolve" or "expand"
+	Reason string
+}
+
+func (e QueryIssued) String() string {
+	return fmt.Sprintf("query %s", e.Query)
+}
+
+func (e QueryCompleted) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("query %s failed after %s: %s", e.Query, e.Duration.Round(time.Millisecond), e.Err)
+	}
+	return fmt.Sprintf("query %s: %d results, %d bytes in %s", e.Query, e.Results, e.Size, e.Duration.Round(time.Millisecond))
+}
+
+func (e SourceDownloaded) String() string {
+	return fmt.Sprintf("source %s: %d bytes in %s", e.ID, e.Size, e.Duration.Round(time.Millisecond))
+}
+
+func (e ReferencesParsed) String() string {
+	return fmt.Sprintf("references %s: %d entries in %s", e.ID, e.Count, e.Duration.Round(time.Millisecond))
+}
+
+func (e ReferenceResolved) String() string {
+	return fmt.Sprintf("resolved %s -> %s %.60s", e.ParentID, e.ID, e.Title)
+}
+
+func (e NodeAdded) String() string {
+	return fmt.Sprintf("node %s at depth %d: %.60s", e.ID, e.Depth, e.Title)
+}
+
+func (e PDFSaved) String() string {
+	return fmt.Sprintf("pdf %s: %d bytes in %s", e.ID, e.Size, e.Duration.Round(time.Millisecond))
+}
+
+func (e NodeFailed) String() string {
+	id := e.ID
+	if id == "" {
+		id = fmt.Sprintf("%.40q", e.Title)
+	}
+	if e.Depth < 0 {
+		return fmt.Sprintf("%s failed for %s: %s", e.Stage, id, e.Err)
+	}
+	return fmt.Sprintf("%s failed for %s at depth %d: %s", e.Stage, id, e.Depth, e.Err)
+}
+
+func (e NodeFiltered) String() string {
+	id := e.ID
+	if id == "" {
+		id = fmt.Sprintf("%.40q", e.Title)
+	}
+	return fmt.Sprintf("filtered %s at %s, depth %d: %s", id, e.Stage, e.Depth, e.Reason)
+}
+
+// Bus carries every event emitted in this process
+var Bus = bus.MakeTopic[Event]()
+
+func Emit(e Event) {
+	Bus.Publish(e)
+}
+
+// the constructors below stamp the event time
+
+func Query(q string) QueryIssued {
+	return QueryIssued{At: now(), Query: q}
+}
+
+func Completed(q string, results, size int, d time.Duration, err error) QueryCompleted {
+	return QueryCompleted{At: now(), Query: q, Results: results, Size: size, Duration: d, Err: err}
+}
+
+func Source(id string, size int, d time.Duration) SourceDownloaded {
+	return SourceDownloaded{At: now(), ID: id, Size: size, Duration: d}
+}
+
+func References(id string, count int, d time.Duration) ReferencesParsed {
+	return ReferencesParsed{At: now(), ID: id, Count: count, Duration: d}
+}
+
+func Resolved(parentID, id, title string, d time.Duration) ReferenceResolved {
+	return ReferenceResolved{At: now(), ParentID: parentID, ID: id, Title: title, Duration: d}
+}
+
+func Node(id, parentID, title string, depth int) NodeAdded {
+	return NodeAdded{At: now(), ID: id, ParentID: parentID, Title: title, Depth: depth}
+}
+
+func PDF(id, path string, size int, d time.Duration) PDFSaved {
+	return PDFSaved{At: now(), ID: id, Path: path, Size: size, Duration: d}
+}
+
+func Failed(id, title string, depth int, stage string, err error) NodeFailed {
+	return NodeFailed{At: now(), ID: id, Title: title, Depth: depth, Stage: stage, Err: err}
+}
+
+func Filtered(id, title string, depth int, stage, reason string) NodeFiltered {
+	return NodeFiltered{At: now(), ID: id, Title: title, Depth: depth, Stage: stage, Reason: reason}
+}
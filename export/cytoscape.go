@@ -0,0 +1,63 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/benjaminchristie/go-arxiv-tree/tree"
+)
+
+func init() {
+	Register(Format{
+		Name:        "cytoscape",
+		Extensions:  []string{".cyjs"},
+		Description: "Cytoscape.js elements JSON, for Cytoscape and the web",
+		Write:       WriteCytoscape,
+	})
+}
+
+type cytoscapeElement struct {
+	Data map[string]any `json:"data"`
+}
+
+// WriteCytoscape writes the elements format Cytoscape.js and Cytoscape
+// desktop both import
+func WriteCytoscape(w io.Writer, g *tree.Graph) error {
+	var doc struct {
+		Elements struct {
+			Nodes []cytoscapeElement `json:"nodes"`
+			Edges []cytoscapeElement `json:"edges"`
+		} `json:"elements"`
+	}
+	doc.Elements.Nodes = []cytoscapeElement{}
+	doc.Elements.Edges = []cytoscapeElement{}
+	for _, n := range nodes(g) {
+		data := map[string]any{
+			"id":         n.ID,
+			"label":      n.Title,
+			"title":      n.Title,
+			"author":     n.Author,
+			"authors":    n.Authors,
+			"year":       n.Year,
+			"category":   n.Category,
+			"categories": n.Categories,
+			"depth":      n.Depth,
+			"seeds":      n.Seeds,
+			"status":     n.Status,
+			"confidence": n.Confidence,
+		}
+		doc.Elements.Nodes = append(doc.Elements.Nodes, cytoscapeElement{Data: data})
+	}
+	for i, e := range g.Edges() {
+		data := map[string]any{
+			"id":     fmt.Sprintf("e%d", i),
+			"source": e.From,
+			"target": e.To,
+		}
+		doc.Elements.Edges = append(doc.Elements.Edges, cytoscapeElement{Data: data})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
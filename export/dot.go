@@ -0,0 +1,18 @@
+package export
+
+import (
+	"io"
+
+	"github.com/benjaminchristie/go-arxiv-tree/tree"
+)
+
+func init() {
+	Register(Format{
+		Name:        "dot",
+		Extensions:  []string{".dot", ".gv"},
+		Description: "Graphviz DOT, colored by depth",
+		Write: func(w io.Writer, g *tree.Graph) error {
+			return tree.WriteGraphDOT(w, g, tree.DefaultDOTOptions)
+		},
+	})
+}
@@ -0,0 +1,164 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/benjaminchristie/go-arxiv-tree/tree"
+)
+
+// Format writes a whole graph in one file format
+type Format struct {
+	Name        string
+	Extensions  []string // with the dot, the first is preferred
+	Description string
+	Write       func(w io.Writer, g *tree.Graph) error
+}
+
+var lock sync.RWMutex
+var formats = map[string]Format{}
+
+// Register makes f available under its name, replacing any format
+// registered before under the same name
+func Register(f Format) {
+	lock.Lock()
+	defer lock.Unlock()
+	formats[f.Name] = f
+}
+
+// Formats returns every registered format sorted by name
+func Formats() []Format {
+	lock.RLock()
+	defer lock.RUnlock()
+	fs := make([]Format, 0, len(formats))
+	for _, f := range formats {
+		fs = append(fs, f)
+	}
+	sort.Slice(fs, func(i, j int) bool {
+		return fs[i].Name < fs[j].Name
+	})
+	return fs
+}
+
+func Lookup(name string) (Format, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	f, ok := formats[strings.ToLower(name)]
+	return f, ok
+}
+
+// ForFile picks the format whose extension filename has
+func ForFile(filename string) (Format, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, f := range Formats() {
+		for _, e := range f.Extensions {
+			if e == ext {
+				return f, true
+			}
+		}
+	}
+	return Format{}, false
+}
+
+// WriteFile exports g to filename in the named format, or in the format
+// its extension suggests when format is empty
+func WriteFile(g *tree.Graph, filename, format string) error {
+	var f Format
+	var ok bool
+	if format != "" {
+		f, ok = Lookup(format)
+		if !ok {
+			return fmt.Errorf("unknown export format %q, want one of %s", format, strings.Join(Names(), ", "))
+		}
+	} else {
+		f, ok = ForFile(filename)
+		if !ok {
+			return fmt.Errorf("cannot tell the export format of %s, pass one of %s", filename, strings.Join(Names(), ", "))
+		}
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = f.Write(file, g)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func Names() []string {
+	var names []string
+	for _, f := range Formats() {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// node is what every format carries for a paper
+type node struct {
+	ID         string
+	Title      string
+	Author     string
+	Authors    string // "; " separated
+	Year       int
+	Category   string
+	Categories string // "; " separated
+	Depth      int
+	Seeds      string // "id:distance; " separated, in the order of the seeds
+	Status     string
+	Confidence float64
+}
+
+func nodes(g *tree.Graph) []node {
+	var ns []node
+	seeds := g.Seeds()
+	for _, n := range g.Nodes() {
+		var reached []string
+		for _, s := range seeds {
+			if d, ok := n.Seeds[s]; ok {
+				reached = append(reached, fmt.Sprintf("%s:%d", s, d))
+			}
+		}
+		ns = append(ns, node{
+			ID:         n.ID,
+			Title:      n.Info.Title,
+			Author:     n.Info.Author,
+			Authors:    strings.Join(n.Info.Authors, "; "),
+			Year:       n.Info.Year(),
+			Category:   n.Info.Category,
+			Categories: strings.Join(n.Info.Categories, "; "),
+			Depth:      n.Depth,
+			Seeds:      strings.Join(reached, "; "),
+			Status:     g.Status(n),
+			Confidence: n.Info.Confidence,
+		})
+	}
+	return ns
+}
+
+// attribute describes one field of node for the formats that declare
+// their attributes up front
+type attribute struct {
+	Name  string
+	Type  string // "string", "int" or "double"
+	Value func(n node) string
+}
+
+var attributes = []attribute{
+	{"title", "string", func(n node) string { return n.Title }},
+	{"author", "string", func(n node) string { return n.Author }},
+	{"authors", "string", func(n node) string { return n.Authors }},
+	{"year", "int", func(n node) string { return fmt.Sprint(n.Year) }},
+	{"category", "string", func(n node) string { return n.Category }},
+	{"categories", "string", func(n node) string { return n.Categories }},
+	{"depth", "int", func(n node) string { return fmt.Sprint(n.Depth) }},
+	{"seeds", "string", func(n node) string { return n.Seeds }},
+	{"status", "string", func(n node) string { return n.Status }},
+	{"confidence", "double", func(n node) string { return fmt.Sprint(n.Confidence) }},
+}
@@ -0,0 +1,81 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/benjaminchristie/go-arxiv-tree/tree"
+)
+
+func init() {
+	Register(Format{
+		Name:        "gexf",
+		Extensions:  []string{".gexf"},
+		Description: "GEXF 1.3, for Gephi",
+		Write:       WriteGEXF,
+	})
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfNode struct {
+	ID     string      `xml:"id,attr"`
+	Label  string      `xml:"label,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+type gexf struct {
+	XMLName xml.Name `xml:"gexf"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Graph   struct {
+		DefaultEdgeType string `xml:"defaultedgetype,attr"`
+		Attributes      struct {
+			Class      string          `xml:"class,attr"`
+			Attributes []gexfAttribute `xml:"attribute"`
+		} `xml:"attributes"`
+		Nodes []gexfNode `xml:"nodes>node"`
+		Edges []gexfEdge `xml:"edges>edge"`
+	} `xml:"graph"`
+}
+
+func WriteGEXF(w io.Writer, g *tree.Graph) error {
+	doc := gexf{XMLNS: "http://gexf.net/1.3", Version: "1.3"}
+	doc.Graph.DefaultEdgeType = "directed"
+	doc.Graph.Attributes.Class = "node"
+	for _, a := range attributes {
+		// GEXF calls integers "integer"
+		t := a.Type
+		if t == "int" {
+			t = "integer"
+		}
+		doc.Graph.Attributes.Attributes = append(doc.Graph.Attributes.Attributes, gexfAttribute{ID: a.Name, Title: a.Name, Type: t})
+	}
+	for _, n := range nodes(g) {
+		gn := gexfNode{ID: n.ID, Label: n.Title}
+		for _, a := range attributes {
+			gn.Values = append(gn.Values, gexfValue{For: a.Name, Value: a.Value(n)})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gn)
+	}
+	for i, e := range g.Edges() {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{ID: fmt.Sprintf("e%d", i), Source: e.From, Target: e.To})
+	}
+	return writeXML(w, doc)
+}
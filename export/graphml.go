@@ -0,0 +1,88 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/benjaminchristie/go-arxiv-tree/tree"
+)
+
+func init() {
+	Register(Format{
+		Name:        "graphml",
+		Extensions:  []string{".graphml"},
+		Description: "GraphML, for Gephi, yEd and NetworkX",
+		Write:       WriteGraphML,
+	})
+}
+
+type graphmlKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphmlNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+type graphml struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphmlNode `xml:"node"`
+		Edges       []graphmlEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+func WriteGraphML(w io.Writer, g *tree.Graph) error {
+	doc := graphml{XMLNS: "http://graphml.graphdrawing.org/xmlns"}
+	for _, a := range attributes {
+		doc.Keys = append(doc.Keys, graphmlKey{ID: a.Name, For: "node", Name: a.Name, Type: a.Type})
+	}
+	doc.Graph.ID = g.Root().ID
+	doc.Graph.EdgeDefault = "directed"
+	for _, n := range nodes(g) {
+		gn := graphmlNode{ID: n.ID}
+		for _, a := range attributes {
+			gn.Data = append(gn.Data, graphmlData{Key: a.Name, Value: a.Value(n)})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gn)
+	}
+	for i, e := range g.Edges() {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphmlEdge{ID: fmt.Sprintf("e%d", i), Source: e.From, Target: e.To})
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc any) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,59 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/benjaminchristie/go-arxiv-tree/tree"
+)
+
+func init() {
+	Register(Format{
+		Name:        "mermaid",
+		Extensions:  []string{".mmd", ".mermaid"},
+		Description: "Mermaid flowchart, for Markdown docs",
+		Write:       WriteMermaid,
+	})
+}
+
+// mermaidText escapes s for a quoted Mermaid label
+func mermaidText(s string) string {
+	r := strings.NewReplacer(`"`, "#quot;", "\n", " ", "<", "#lt;", ">", "#gt;")
+	return r.Replace(s)
+}
+
+// WriteMermaid writes a left to right flowchart. Mermaid has no free form
+// attributes, so the status becomes a class, the rest goes in the label and
+// every node links to its abstract.
+func WriteMermaid(w io.Writer, g *tree.Graph) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "flowchart LR")
+	ids := make(map[string]string)
+	for i, n := range nodes(g) {
+		id := fmt.Sprintf("n%d", i)
+		ids[n.ID] = id
+		label := fmt.Sprintf("%s<br/><small>%s", mermaidText(n.Title), n.ID)
+		if n.Author != "" {
+			label += ", " + mermaidText(n.Author)
+		}
+		if n.Year != 0 {
+			label += fmt.Sprintf(", %d", n.Year)
+		}
+		if n.Category != "" {
+			label += ", " + n.Category
+		}
+		label += "</small>"
+		fmt.Fprintf(b, "  %s[\"%s\"]:::%s\n", id, label, n.Status)
+		fmt.Fprintf(b, "  click %s \"https://arxiv.org/abs/%s\"\n", id, n.ID)
+	}
+	for _, e := range g.Edges() {
+		fmt.Fprintf(b, "  %s --> %s\n", ids[e.From], ids[e.To])
+	}
+	fmt.Fprintf(b, "  classDef %s fill:#d4f4dd,stroke:#2e7d32\n", tree.StatusExpanded)
+	fmt.Fprintf(b, "  classDef %s fill:#eeeeee,stroke:#757575\n", tree.StatusUnexpanded)
+	fmt.Fprintf(b, "  classDef %s fill:#fddede,stroke:#c62828\n", tree.StatusFailed)
+	fmt.Fprintf(b, "  classDef %s fill:#dde8f7,stroke:#1565c0\n", tree.StatusFiltered)
+	return b.Flush()
+}
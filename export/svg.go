@@ -0,0 +1,170 @@
+package export
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/benjaminchristie/go-arxiv-tree/layout"
+	"github.com/benjaminchristie/go-arxiv-tree/tree"
+)
+
+func init() {
+	Register(Format{
+		Name:        "svg",
+		Extensions:  []string{".svg"},
+		Description: "SVG picture, laid out without Graphviz",
+		Write: func(w io.Writer, g *tree.Graph) error {
+			return WriteSVG(w, g, tree.DefaultDOTOptions)
+		},
+	})
+}
+
+// Visualize draws g to filename, as SVG if its extension is .svg and as
+// DOT otherwise
+func Visualize(g *tree.Graph, filename string, o tree.DOTOptions) error {
+	if !strings.EqualFold(filepath.Ext(filename), ".svg") {
+		return tree.VisualizeGraph(g, filename, o)
+	}
+	err := o.Validate()
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = WriteSVG(file, g, o)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+const svgFontSize = 11
+
+// WriteSVG lays g out left to right and draws it. Nodes are colored and
+// sized as o says, link to their abs page and show their abstract on
+// hover. Clusters and collapsed leaves are left to the DOT output.
+func WriteSVG(w io.Writer, g *tree.Graph, o tree.DOTOptions) error {
+	type box struct {
+		node  tree.Node
+		lines []string
+		font  float64
+	}
+	var nodes []layout.Node
+	boxes := map[string]box{}
+	for _, n := range g.Nodes() {
+		b := box{
+			node:  n,
+			lines: strings.Split(n.Info.Label(), "\n"),
+			font:  svgFontSize,
+		}
+		if o.SizeByCitations && len(n.CitedBy) > 1 {
+			b.font = math.Min(svgFontSize+3*math.Sqrt(float64(len(n.CitedBy)-1)), 26)
+		}
+		longest := 0
+		for _, l := range b.lines {
+			longest = max(longest, len([]rune(l)))
+		}
+		boxes[n.ID] = b
+		nodes = append(nodes, layout.Node{
+			ID:     n.ID,
+			Width:  float64(longest)*b.font*0.6 + 16,
+			Height: float64(len(b.lines))*b.font*1.3 + 10,
+		})
+	}
+	var edges []layout.Edge
+	for _, e := range g.Edges() {
+		edges = append(edges, layout.Edge{From: e.From, To: e.To})
+	}
+	l := layout.Layered(nodes, edges, layout.DefaultOptions)
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="Helvetica, Arial, sans-serif">
+<defs>
+  <marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="7" markerHeight="7" orient="auto">
+    <path d="M0,0 L10,5 L0,10 z" fill="#888888"/>
+  </marker>
+  <marker id="path-arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="5" markerHeight="5" orient="auto">
+    <path d="M0,0 L10,5 L0,10 z" fill="%s"/>
+  </marker>
+</defs>
+<rect width="100%%" height="100%%" fill="#ffffff"/>
+`, l.Width, l.Height, l.Width, l.Height, tree.PathColor)
+
+	onPath, pathEdges := o.Highlight(g)
+	fmt.Fprintln(out, `<g fill="none" stroke="#888888">`)
+	for _, r := range l.Routes {
+		if pathEdges[tree.Edge{From: r.From, To: r.To}] {
+			fmt.Fprintf(out, "  <path d=\"%s\" stroke=\"%s\" stroke-width=\"2.5\" marker-end=\"url(#path-arrow)\"/>\n", curve(r.Points), tree.PathColor)
+			continue
+		}
+		fmt.Fprintf(out, "  <path d=\"%s\" marker-end=\"url(#arrow)\"/>\n", curve(r.Points))
+	}
+	fmt.Fprintln(out, "</g>")
+
+	for _, n := range nodes {
+		b := boxes[n.ID]
+		p := l.Boxes[n.ID]
+		fill := g.Color(b.node, o.Color)
+		if fill == "" {
+			fill = "#ffffff"
+		}
+		stroke, outline := 1.0, "#444444"
+		switch {
+		case onPath[n.ID]:
+			stroke, outline = 3, tree.PathColor
+		case b.node.Depth == 0:
+			stroke = 2
+		}
+		tip := b.node.Info.Title
+		if b.node.Info.Abstract != "" {
+			tip += "\n\n" + b.node.Info.Abstract
+		}
+		fmt.Fprintf(out, "<a xlink:href=\"%s\" href=\"%s\" target=\"_blank\">\n", esc(absURL(n.ID)), esc(absURL(n.ID)))
+		fmt.Fprintf(out, "  <title>%s</title>\n", esc(tip))
+		fmt.Fprintf(out, "  <rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" rx=\"6\" fill=\"%s\" stroke=\"%s\" stroke-width=\"%.0f\"/>\n",
+			p.X-p.Width/2, p.Y-p.Height/2, p.Width, p.Height, fill, outline, stroke)
+		fmt.Fprintf(out, "  <text x=\"%.1f\" font-size=\"%.1f\" text-anchor=\"middle\" fill=\"#000000\">\n", p.X, b.font)
+		top := p.Y - float64(len(b.lines)-1)*b.font*1.3/2 + b.font*0.35
+		for i, line := range b.lines {
+			fmt.Fprintf(out, "    <tspan x=\"%.1f\" y=\"%.1f\">%s</tspan>\n", p.X, top+float64(i)*b.font*1.3, esc(line))
+		}
+		fmt.Fprintln(out, "  </text>")
+		fmt.Fprintln(out, "</a>")
+	}
+	fmt.Fprintln(out, "</svg>")
+	return out.Flush()
+}
+
+func absURL(id string) string {
+	return "https://arxiv.org/abs/" + id
+}
+
+// curve joins points with horizontal S-curves
+func curve(points []layout.Point) string {
+	if len(points) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "M%.1f,%.1f", points[0].X, points[0].Y)
+	for i := 1; i < len(points); i++ {
+		p, q := points[i-1], points[i]
+		dx := (q.X - p.X) / 2
+		fmt.Fprintf(&b, " C%.1f,%.1f %.1f,%.1f %.1f,%.1f", p.X+dx, p.Y, q.X-dx, q.Y, q.X, q.Y)
+	}
+	return b.String()
+}
+
+func esc(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -3,7 +3,6 @@ module github.com/benjaminchristie/go-arxiv-tree
 go 1.22.3
 
 require (
-	github.com/dominikbraun/graph v0.23.0
 	github.com/gdamore/tcell/v2 v2.7.4
 	github.com/jschaf/bibtex v0.0.0-20230605202944-017d10381faa
 	github.com/navidys/tvxwidgets v0.6.0
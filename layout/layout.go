@@ -0,0 +1,371 @@
+package layout
+
+import (
+	"sort"
+)
+
+// Node is a box to place. IDs must be unique.
+type Node struct {
+	ID     string
+	Width  float64
+	Height float64
+}
+
+type Edge struct {
+	From string
+	To   string
+}
+
+type Point struct {
+	X float64
+	Y float64
+}
+
+// Box is where a node went, X and Y being its center
+type Box struct {
+	ID     string
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+}
+
+// Route is the polyline an edge follows, from the right side of From to
+// the left side of To, or the other way round if the edge had to be
+// turned around to break a cycle
+type Route struct {
+	From     string
+	To       string
+	Points   []Point
+	Reversed bool
+}
+
+type Layout struct {
+	Boxes  map[string]Box
+	Routes []Route
+	Width  float64
+	Height float64
+}
+
+type Options struct {
+	RankSep float64 // horizontal gap between layers
+	NodeSep float64 // vertical gap between boxes in a layer
+	Margin  float64
+	Sweeps  int // rounds of crossing reduction
+}
+
+var DefaultOptions = Options{
+	RankSep: 60,
+	NodeSep: 16,
+	Margin:  20,
+	Sweeps:  8,
+}
+
+// vertex is a node or a dummy standing in for an edge where it crosses a
+// layer
+type vertex struct {
+	node  int // index into nodes, -1 for dummies
+	w, h  float64
+	layer int
+	order int
+	y     float64
+	in    []int
+	out   []int
+}
+
+// Layered places nodes left to right in layers so that every edge points
+// right, Sugiyama style. Edges that would close a cycle are turned around
+// first. Edges to unknown nodes and self loops are dropped.
+func Layered(nodes []Node, edges []Edge, o Options) Layout {
+	index := make(map[string]int, len(nodes))
+	vs := make([]*vertex, len(nodes))
+	for i, n := range nodes {
+		index[n.ID] = i
+		vs[i] = &vertex{node: i, w: n.Width, h: n.Height}
+	}
+	type arc struct {
+		from, to int
+		edge     int
+		reversed bool
+	}
+	var arcs []arc
+	seen := map[[2]int]bool{}
+	for i, e := range edges {
+		f, okf := index[e.From]
+		t, okt := index[e.To]
+		if !okf || !okt || f == t || seen[[2]int{f, t}] {
+			continue
+		}
+		seen[[2]int{f, t}] = true
+		arcs = append(arcs, arc{from: f, to: t, edge: i})
+	}
+
+	// break cycles by reversing the edges depth first search finds going
+	// back up its stack
+	succ := make([][]int, len(nodes))
+	for i, a := range arcs {
+		succ[a.from] = append(succ[a.from], i)
+	}
+	state := make([]int, len(nodes)) // 0 unseen, 1 on stack, 2 done
+	var visit func(v int)
+	visit = func(v int) {
+		state[v] = 1
+		for _, i := range succ[v] {
+			switch state[arcs[i].to] {
+			case 0:
+				visit(arcs[i].to)
+			case 1:
+				arcs[i].reversed = true
+			}
+		}
+		state[v] = 2
+	}
+	for v := range nodes {
+		if state[v] == 0 {
+			visit(v)
+		}
+	}
+	for i := range arcs {
+		if arcs[i].reversed {
+			arcs[i].from, arcs[i].to = arcs[i].to, arcs[i].from
+		}
+		vs[arcs[i].from].out = append(vs[arcs[i].from].out, arcs[i].to)
+		vs[arcs[i].to].in = append(vs[arcs[i].to].in, arcs[i].from)
+	}
+
+	// longest path layering, in topological order
+	pending := make([]int, len(nodes))
+	var queue []int
+	for v := range nodes {
+		pending[v] = len(vs[v].in)
+		if pending[v] == 0 {
+			queue = append(queue, v)
+		}
+	}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, t := range vs[v].out {
+			vs[t].layer = max(vs[t].layer, vs[v].layer+1)
+			pending[t]--
+			if pending[t] == 0 {
+				queue = append(queue, t)
+			}
+		}
+	}
+
+	// replace long edges with chains of dummies
+	chains := make([][]int, len(arcs))
+	for _, v := range vs {
+		v.in, v.out = nil, nil
+	}
+	for i, a := range arcs {
+		chain := []int{a.from}
+		for l := vs[a.from].layer + 1; l < vs[a.to].layer; l++ {
+			vs = append(vs, &vertex{node: -1, layer: l})
+			chain = append(chain, len(vs)-1)
+		}
+		chain = append(chain, a.to)
+		for j := 1; j < len(chain); j++ {
+			vs[chain[j-1]].out = append(vs[chain[j-1]].out, chain[j])
+			vs[chain[j]].in = append(vs[chain[j]].in, chain[j-1])
+		}
+		chains[i] = chain
+	}
+
+	layers := order(vs, o.Sweeps)
+	place(vs, layers, o)
+
+	// layer columns are as wide as their widest box
+	x := make([]float64, len(layers))
+	left := o.Margin
+	for l, layer := range layers {
+		width := 0.0
+		for _, v := range layer {
+			width = max(width, vs[v].w)
+		}
+		x[l] = left + width/2
+		left += width + o.RankSep
+	}
+
+	out := Layout{Boxes: make(map[string]Box, len(nodes))}
+	for _, v := range vs {
+		if v.node < 0 {
+			continue
+		}
+		n := nodes[v.node]
+		b := Box{ID: n.ID, X: x[v.layer], Y: v.y, Width: n.Width, Height: n.Height}
+		out.Boxes[n.ID] = b
+		out.Width = max(out.Width, b.X+b.Width/2+o.Margin)
+		out.Height = max(out.Height, b.Y+b.Height/2+o.Margin)
+	}
+	for i, a := range arcs {
+		var pts []Point
+		for j, v := range chains[i] {
+			p := Point{X: x[vs[v].layer], Y: vs[v].y}
+			switch j {
+			case 0:
+				p.X += vs[v].w / 2
+			case len(chains[i]) - 1:
+				p.X -= vs[v].w / 2
+			}
+			pts = append(pts, p)
+		}
+		e := edges[a.edge]
+		if a.reversed {
+			for l, r := 0, len(pts)-1; l < r; l, r = l+1, r-1 {
+				pts[l], pts[r] = pts[r], pts[l]
+			}
+		}
+		out.Routes = append(out.Routes, Route{From: e.From, To: e.To, Points: pts, Reversed: a.reversed})
+	}
+	return out
+}
+
+// order groups the vertices into layers and orders each layer to reduce
+// crossings, sweeping down and up with the barycenter heuristic and
+// keeping the best ordering seen
+func order(vs []*vertex, sweeps int) [][]int {
+	var layers [][]int
+	for i, v := range vs {
+		for len(layers) <= v.layer {
+			layers = append(layers, nil)
+		}
+		v.order = len(layers[v.layer])
+		layers[v.layer] = append(layers[v.layer], i)
+	}
+	best := clone(layers)
+	fewest := crossings(vs, layers)
+	for s := 0; s < sweeps && fewest > 0; s++ {
+		if s%2 == 0 {
+			for l := 1; l < len(layers); l++ {
+				sortLayer(vs, layers[l], func(v *vertex) []int { return v.in })
+			}
+		} else {
+			for l := len(layers) - 2; l >= 0; l-- {
+				sortLayer(vs, layers[l], func(v *vertex) []int { return v.out })
+			}
+		}
+		if c := crossings(vs, layers); c < fewest {
+			fewest = c
+			best = clone(layers)
+		}
+	}
+	for _, layer := range best {
+		for i, v := range layer {
+			vs[v].order = i
+		}
+	}
+	return best
+}
+
+func sortLayer(vs []*vertex, layer []int, adjacent func(*vertex) []int) {
+	bary := make(map[int]float64, len(layer))
+	for _, v := range layer {
+		adj := adjacent(vs[v])
+		if len(adj) == 0 {
+			bary[v] = float64(vs[v].order)
+			continue
+		}
+		sum := 0.0
+		for _, a := range adj {
+			sum += float64(vs[a].order)
+		}
+		bary[v] = sum / float64(len(adj))
+	}
+	sort.SliceStable(layer, func(i, j int) bool {
+		return bary[layer[i]] < bary[layer[j]]
+	})
+	for i, v := range layer {
+		vs[v].order = i
+	}
+}
+
+func crossings(vs []*vertex, layers [][]int) int {
+	n := 0
+	for _, layer := range layers {
+		var ends [][2]int
+		for _, v := range layer {
+			for _, t := range vs[v].out {
+				ends = append(ends, [2]int{vs[v].order, vs[t].order})
+			}
+		}
+		for i := range ends {
+			for j := i + 1; j < len(ends); j++ {
+				a, b := ends[i], ends[j]
+				if (a[0] < b[0] && a[1] > b[1]) || (a[0] > b[0] && a[1] < b[1]) {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+func clone(layers [][]int) [][]int {
+	c := make([][]int, len(layers))
+	for i, l := range layers {
+		c[i] = append([]int(nil), l...)
+	}
+	return c
+}
+
+// place sets the vertical centers of the vertices. Each layer is pulled
+// toward the centers of its neighbors a few times, keeping its order and
+// the gaps between boxes.
+func place(vs []*vertex, layers [][]int, o Options) {
+	gap := func(a, b int) float64 {
+		return vs[a].h/2 + o.NodeSep + vs[b].h/2
+	}
+	for _, layer := range layers {
+		y := o.Margin
+		for i, v := range layer {
+			if i > 0 {
+				y += gap(layer[i-1], v)
+			} else {
+				y += vs[v].h / 2
+			}
+			vs[v].y = y
+		}
+	}
+	for round := 0; round < 4; round++ {
+		for _, layer := range layers {
+			want := make([]float64, len(layer))
+			for i, v := range layer {
+				want[i] = vs[v].y
+				adj := append(append([]int(nil), vs[v].in...), vs[v].out...)
+				if len(adj) == 0 {
+					continue
+				}
+				sum := 0.0
+				for _, a := range adj {
+					sum += vs[a].y
+				}
+				want[i] = sum / float64(len(adj))
+			}
+			// pushing down from the top and up from the bottom both keep
+			// the gaps, and so does their average
+			down := append([]float64(nil), want...)
+			for i := 1; i < len(layer); i++ {
+				down[i] = max(down[i], down[i-1]+gap(layer[i-1], layer[i]))
+			}
+			up := append([]float64(nil), want...)
+			for i := len(layer) - 2; i >= 0; i-- {
+				up[i] = min(up[i], up[i+1]-gap(layer[i], layer[i+1]))
+			}
+			for i, v := range layer {
+				vs[v].y = (down[i] + up[i]) / 2
+			}
+		}
+	}
+	top := 0.0
+	first := true
+	for _, v := range vs {
+		if t := v.y - v.h/2; first || t < top {
+			top, first = t, false
+		}
+	}
+	for _, v := range vs {
+		v.y += o.Margin - top
+	}
+}
@@ -0,0 +1,102 @@
+package layout
+
+import (
+	"math"
+	"testing"
+)
+
+func boxes(ids ...string) []Node {
+	var nodes []Node
+	for _, id := range ids {
+		nodes = append(nodes, Node{ID: id, Width: 40, Height: 20})
+	}
+	return nodes
+}
+
+func near(a, b Point) bool {
+	return math.Abs(a.X-b.X) < 1e-9 && math.Abs(a.Y-b.Y) < 1e-9
+}
+
+// checkEnds checks r starts and ends on the sides of its boxes that face
+// along the layers, which are swapped for a reversed edge
+func checkEnds(t *testing.T, l Layout, r Route) {
+	t.Helper()
+	from, to := l.Boxes[r.From], l.Boxes[r.To]
+	start := Point{X: from.X + from.Width/2, Y: from.Y}
+	end := Point{X: to.X - to.Width/2, Y: to.Y}
+	if r.Reversed {
+		start.X, end.X = from.X-from.Width/2, to.X+to.Width/2
+	}
+	if first := r.Points[0]; !near(first, start) {
+		t.Errorf("%s>%s starts at %v, want %v", r.From, r.To, first, start)
+	}
+	if last := r.Points[len(r.Points)-1]; !near(last, end) {
+		t.Errorf("%s>%s ends at %v, want %v", r.From, r.To, last, end)
+	}
+}
+
+func TestLayeredCycle(t *testing.T) {
+	edges := []Edge{{"a", "b"}, {"b", "c"}, {"c", "a"}}
+	l := Layered(boxes("a", "b", "c"), edges, DefaultOptions)
+	if a, b, c := l.Boxes["a"], l.Boxes["b"], l.Boxes["c"]; !(a.X < b.X && b.X < c.X) {
+		t.Fatalf("layers at x %v, %v, %v, want a, b and c left to right", a.X, b.X, c.X)
+	}
+	if len(l.Routes) != len(edges) {
+		t.Fatalf("%d routes, want %d", len(l.Routes), len(edges))
+	}
+	for i, r := range l.Routes {
+		if r.From != edges[i].From || r.To != edges[i].To {
+			t.Errorf("route %d is %s>%s, want %s>%s", i, r.From, r.To, edges[i].From, edges[i].To)
+		}
+		if want := r.From == "c"; r.Reversed != want {
+			t.Errorf("%s>%s Reversed = %v, want %v", r.From, r.To, r.Reversed, want)
+		}
+		checkEnds(t, l, r)
+	}
+	// c>a runs back across b's layer
+	if back := l.Routes[2]; len(back.Points) != 3 || back.Points[1].X != l.Boxes["b"].X {
+		t.Errorf("c>a goes through %v, want one bend in b's layer", back.Points)
+	}
+}
+
+func TestLayeredLongEdge(t *testing.T) {
+	edges := []Edge{{"a", "b"}, {"b", "c"}, {"c", "d"}, {"a", "d"}}
+	o := DefaultOptions
+	l := Layered(boxes("a", "b", "c", "d"), edges, o)
+	var long Route
+	for _, r := range l.Routes {
+		checkEnds(t, l, r)
+		if r.Reversed {
+			t.Errorf("%s>%s reversed in a graph without cycles", r.From, r.To)
+		}
+		if r.From == "a" && r.To == "d" {
+			long = r
+		} else if len(r.Points) != 2 {
+			t.Errorf("%s>%s has %d points, want a straight line", r.From, r.To, len(r.Points))
+		}
+	}
+	if len(long.Points) != 4 {
+		t.Fatalf("a>d has points %v, want one in each of the two layers it crosses", long.Points)
+	}
+	for i, id := range []string{"b", "c"} {
+		p, box := long.Points[i+1], l.Boxes[id]
+		if p.X != box.X {
+			t.Errorf("a>d crosses %s's layer at x %v, want %v", id, p.X, box.X)
+		}
+		// a dummy is a box of no height, kept NodeSep clear of the others
+		if d := math.Abs(p.Y - box.Y); d < box.Height/2+o.NodeSep-1e-9 {
+			t.Errorf("a>d passes %v from the middle of %s, want at least %v", d, id, box.Height/2+o.NodeSep)
+		}
+		if p.Y < 0 || p.Y > l.Height {
+			t.Errorf("a>d bends at %v, outside the layout", p)
+		}
+	}
+}
+
+func TestLayeredDropsBadEdges(t *testing.T) {
+	edges := []Edge{{"a", "a"}, {"a", "x"}, {"a", "b"}, {"a", "b"}}
+	l := Layered(boxes("a", "b"), edges, DefaultOptions)
+	if len(l.Routes) != 1 || l.Routes[0].From != "a" || l.Routes[0].To != "b" {
+		t.Errorf("routes %+v, want a>b only", l.Routes)
+	}
+}
@@ -0,0 +1,397 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/benjaminchristie/go-arxiv-tree/arxiv_logger"
+)
+
+// Budget caps the requests made from every process sharing a ledger file.
+// Zero means no cap.
+type Budget struct {
+	Hourly int
+	Daily  int
+}
+
+type UsageRow struct {
+	Day   string
+	Host  string
+	Count int
+}
+
+var ErrBudgetExceeded = errors.New("request budget exceeded")
+
+var logger = log.Logger("ledger")
+
+const (
+	version   = 1
+	hourFmt   = "2006-01-02T15"
+	dayFmt    = "2006-01-02"
+	keepFor   = 90 * 24 * time.Hour
+	warnRatio = 0.8 // warn once usage passes this share of a budget
+)
+
+// on-disk format, counts are keyed by UTC hour and then host
+type file struct {
+	Version int                       `json:"version"`
+	Hours   map[string]map[string]int `json:"hours"`
+}
+
+// Ledger counts requests in a JSON file of hourly counts plus a journal
+// beside it that each request appends a line to. Every compactEvery lines
+// the journal is folded into the file, so a request costs one small append
+// rather than a rewrite of the whole ledger.
+type Ledger struct {
+	Path   string
+	Budget Budget
+	lock   sync.Mutex
+	warned map[string]bool // periods already warned about
+
+	// the file and journal as last read, see sync
+	counts   file
+	loaded   bool
+	baseMod  time.Time
+	baseSize int64
+	offset   int64 // journal bytes already in counts
+	lines    int   // journal lines already in counts
+	broken   bool  // an I/O error has been logged
+}
+
+const compactEvery = 1000
+
+func MakeLedger(path string, b Budget) *Ledger {
+	return &Ledger{
+		Path:   path,
+		Budget: b,
+		warned: make(map[string]bool),
+		counts: file{Version: version, Hours: make(map[string]map[string]int)},
+	}
+}
+
+// DefaultPath is where the ledger lives unless told otherwise
+func DefaultPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "go-arxiv-tree", "ledger.json")
+}
+
+func (l *Ledger) journal() string {
+	return l.Path + ".log"
+}
+
+func (l *Ledger) read() (file, error) {
+	f := file{Version: version, Hours: make(map[string]map[string]int)}
+	b, err := os.ReadFile(l.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return f, err
+	}
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		// start over rather than fail every request, keeping the old file
+		// to look at
+		logger.Warn("corrupt request ledger, starting a new one", "file", l.Path, log.Err(err))
+		return file{Version: version, Hours: make(map[string]map[string]int)}, os.Rename(l.Path, l.Path+".corrupt")
+	}
+	if f.Hours == nil {
+		f.Hours = make(map[string]map[string]int)
+	}
+	for h := range f.Hours {
+		_, err = time.Parse(hourFmt, h)
+		if err != nil {
+			delete(f.Hours, h)
+		}
+	}
+	return f, nil
+}
+
+func (l *Ledger) write(f file) error {
+	now := time.Now().UTC()
+	for h := range f.Hours {
+		t, err := time.Parse(hourFmt, h)
+		if err == nil && now.Sub(t) > keepFor {
+			delete(f.Hours, h)
+		}
+	}
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := l.Path + ".tmp"
+	err = os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.Path)
+}
+
+// stat returns the modification time and size of path, or a size of -1 if
+// there is no such file
+func stat(path string) (time.Time, int64, error) {
+	st, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, -1, nil
+	}
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return st.ModTime(), st.Size(), nil
+}
+
+// sync brings l.counts up to date, reading only the journal lines appended
+// since the last call unless another process has compacted the ledger
+// meanwhile. The caller holds both locks.
+func (l *Ledger) sync() error {
+	mod, size, err := stat(l.Path)
+	if err != nil {
+		return err
+	}
+	_, jsize, err := stat(l.journal())
+	if err != nil {
+		return err
+	}
+	if !l.loaded || !mod.Equal(l.baseMod) || size != l.baseSize || jsize < l.offset {
+		f, err := l.read()
+		if err != nil {
+			return err
+		}
+		l.counts, l.offset, l.lines, l.loaded = f, 0, 0, true
+		l.baseMod, l.baseSize, err = stat(l.Path)
+		if err != nil {
+			return err
+		}
+	}
+	if jsize <= l.offset {
+		return nil
+	}
+	j, err := os.Open(l.journal())
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+	_, err = j.Seek(l.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	b, err := io.ReadAll(j)
+	if err != nil {
+		return err
+	}
+	b = b[:bytes.LastIndexByte(b, '\n')+1] // whole lines only
+	if len(b) == 0 {
+		return nil
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		hour, host, ok := strings.Cut(line, " ")
+		if _, err := time.Parse(hourFmt, hour); ok && err == nil {
+			l.add(hour, host)
+		}
+		l.lines++
+	}
+	l.offset += int64(len(b))
+	return nil
+}
+
+func (l *Ledger) add(hour, host string) {
+	if l.counts.Hours[hour] == nil {
+		l.counts.Hours[hour] = make(map[string]int)
+	}
+	l.counts.Hours[hour][host]++
+}
+
+// appendLine journals one request, compacting the journal once it is long
+// enough. The caller holds both locks and has just called sync.
+func (l *Ledger) appendLine(hour, host string) error {
+	j, err := os.OpenFile(l.journal(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	line := hour + " " + host + "\n"
+	_, err = j.WriteString(line)
+	if cerr := j.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	l.offset += int64(len(line))
+	l.lines++
+	if l.lines < compactEvery {
+		return nil
+	}
+	// a crash between the two steps counts the journal twice, which errs
+	// on the side of the budget
+	err = l.write(l.counts)
+	if err != nil {
+		return err
+	}
+	err = os.Truncate(l.journal(), 0)
+	if err != nil {
+		return err
+	}
+	l.offset, l.lines = 0, 0
+	l.baseMod, l.baseSize, err = stat(l.Path)
+	return err
+}
+
+// with runs fn holding the in-process lock and, if it can be had, the
+// file lock, with l.counts up to date. shared is false when the ledger
+// could not be locked or read, and fn then sees the counts of this process
+// only. The I/O error is logged once and returned unless fn fails.
+func (l *Ledger) with(fn func(shared bool) error) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	ioErr := os.MkdirAll(filepath.Dir(l.Path), 0755)
+	if ioErr == nil {
+		var unlock func()
+		unlock, ioErr = lockFile(l.Path + ".lock")
+		if ioErr == nil {
+			defer unlock()
+			ioErr = l.sync()
+		}
+	}
+	err := fn(ioErr == nil)
+	if err != nil && !errors.Is(err, ErrBudgetExceeded) {
+		ioErr = err
+	}
+	if ioErr != nil && !l.broken {
+		l.broken = true
+		logger.Warn("request ledger unavailable, counting this process only", "file", l.Path, log.Err(ioErr))
+	}
+	if err != nil {
+		return err
+	}
+	return ioErr
+}
+
+func count(f file, now time.Time) (hourly, daily int) {
+	hour := now.Format(hourFmt)
+	day := now.Format(dayFmt)
+	for h, hosts := range f.Hours {
+		for _, n := range hosts {
+			if h == hour {
+				hourly += n
+			}
+			if h[:len(dayFmt)] == day {
+				daily += n
+			}
+		}
+	}
+	return hourly, daily
+}
+
+// Record counts one request to host, or returns ErrBudgetExceeded without
+// counting it if that would go over budget. The ledger is bookkeeping, so
+// it failing to read or write is logged rather than returned.
+func (l *Ledger) Record(host string) error {
+	err := l.with(func(shared bool) error {
+		now := time.Now().UTC()
+		hourly, daily := count(l.counts, now)
+		if l.Budget.Hourly > 0 && hourly >= l.Budget.Hourly {
+			return fmt.Errorf("%w: %d requests this hour", ErrBudgetExceeded, hourly)
+		}
+		if l.Budget.Daily > 0 && daily >= l.Budget.Daily {
+			return fmt.Errorf("%w: %d requests today", ErrBudgetExceeded, daily)
+		}
+		hour := now.Format(hourFmt)
+		l.add(hour, host)
+		l.warn(hour, hourly+1, l.Budget.Hourly)
+		l.warn(now.Format(dayFmt), daily+1, l.Budget.Daily)
+		if !shared {
+			return nil
+		}
+		return l.appendLine(hour, host)
+	})
+	if errors.Is(err, ErrBudgetExceeded) {
+		return err
+	}
+	return nil
+}
+
+// must hold l.lock
+func (l *Ledger) warn(period string, used, budget int) {
+	if budget <= 0 || l.warned[period] || float64(used) < warnRatio*float64(budget) {
+		return
+	}
+	l.warned[period] = true
+	logger.Warn("request budget nearly used", "period", period, "used", used, "budget", budget)
+}
+
+// Remaining returns how many requests are left this hour and today, or -1
+// where there is no budget. If the ledger cannot be read the counts are
+// those of this process, and err says why.
+func (l *Ledger) Remaining() (hourly, daily int, err error) {
+	err = l.with(func(bool) error {
+		hourly, daily = count(l.counts, time.Now().UTC())
+		return nil
+	})
+	hourly, daily = remaining(hourly, l.Budget.Hourly), remaining(daily, l.Budget.Daily)
+	return hourly, daily, err
+}
+
+func remaining(used, budget int) int {
+	if budget <= 0 {
+		return -1
+	}
+	return max(budget-used, 0)
+}
+
+// Check returns an error describing the shortfall if planned more requests
+// would not fit in what is left of the budget.
+func (l *Ledger) Check(planned int) error {
+	hourly, daily, _ := l.Remaining() // an unreadable ledger is logged by with
+	if hourly >= 0 && planned > hourly {
+		return fmt.Errorf("%d requests planned but only %d left this hour", planned, hourly)
+	}
+	if daily >= 0 && planned > daily {
+		return fmt.Errorf("%d requests planned but only %d left today", planned, daily)
+	}
+	return nil
+}
+
+// Usage returns request counts per day and host for the last days days,
+// oldest first.
+func (l *Ledger) Usage(days int) ([]UsageRow, error) {
+	var rows []UsageRow
+	err := l.with(func(shared bool) error {
+		if !shared {
+			return nil // with says why
+		}
+		since := time.Now().UTC().AddDate(0, 0, -days+1).Format(dayFmt)
+		totals := make(map[[2]string]int)
+		for h, hosts := range l.counts.Hours {
+			day := h[:len(dayFmt)]
+			if day < since {
+				continue
+			}
+			for host, n := range hosts {
+				totals[[2]string{day, host}] += n
+			}
+		}
+		for k, n := range totals {
+			rows = append(rows, UsageRow{Day: k[0], Host: k[1], Count: n})
+		}
+		return nil
+	})
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Day != rows[j].Day {
+			return rows[i].Day < rows[j].Day
+		}
+		return rows[i].Host < rows[j].Host
+	})
+	return rows, err
+}
@@ -0,0 +1,186 @@
+package ledger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPath(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "ledger.json")
+}
+
+func record(t *testing.T, l *Ledger, host string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := l.Record(host); err != nil {
+			t.Fatalf("Record %d: %v", i, err)
+		}
+	}
+}
+
+func remainingOf(t *testing.T, l *Ledger) (int, int) {
+	t.Helper()
+	h, d, err := l.Remaining()
+	if err != nil {
+		t.Fatalf("Remaining: %v", err)
+	}
+	return h, d
+}
+
+func journalLines(t *testing.T, path string) int {
+	t.Helper()
+	b, err := os.ReadFile(path + ".log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(b), "\n")
+}
+
+func TestLedgersShareAFile(t *testing.T) {
+	path := testPath(t)
+	budget := Budget{Hourly: 100, Daily: 100}
+	a := MakeLedger(path, budget)
+	b := MakeLedger(path, budget)
+	record(t, a, "export.arxiv.org", 3)
+	record(t, b, "arxiv.org", 2)
+	record(t, a, "arxiv.org", 1)
+	for _, l := range []*Ledger{a, b} {
+		if h, d := remainingOf(t, l); h != 94 || d != 94 {
+			t.Errorf("Remaining = %d, %d, want 94 left of both", h, d)
+		}
+	}
+	if n := journalLines(t, path); n != 6 {
+		t.Errorf("journal has %d lines, want one per request", n)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ledger written before the journal was long enough to compact: %v", err)
+	}
+
+	rows, err := b.Usage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := time.Now().UTC().Format(dayFmt)
+	want := []UsageRow{{today, "arxiv.org", 3}, {today, "export.arxiv.org", 3}}
+	if len(rows) != len(want) || rows[0] != want[0] || rows[1] != want[1] {
+		t.Errorf("Usage = %v, want %v", rows, want)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	path := testPath(t)
+	a := MakeLedger(path, Budget{})
+	b := MakeLedger(path, Budget{Hourly: 10 * compactEvery})
+	record(t, b, "arxiv.org", 1) // b has read part of the journal
+	record(t, a, "arxiv.org", compactEvery-1)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("no ledger after %d journal lines: %v", compactEvery, err)
+	}
+	if n := journalLines(t, path); n != 0 {
+		t.Errorf("journal still has %d lines after compacting", n)
+	}
+	// b has to notice the journal it was reading was folded into the file
+	record(t, a, "arxiv.org", 1)
+	if h, _ := remainingOf(t, b); h != 10*compactEvery-compactEvery-1 {
+		t.Errorf("hourly left = %d, want %d", h, 10*compactEvery-compactEvery-1)
+	}
+	c := MakeLedger(path, Budget{Daily: 10 * compactEvery})
+	if _, d := remainingOf(t, c); d != 10*compactEvery-compactEvery-1 {
+		t.Errorf("a new ledger sees %d left today, want %d", d, 10*compactEvery-compactEvery-1)
+	}
+}
+
+func TestRollover(t *testing.T) {
+	path := testPath(t)
+	now := time.Now().UTC()
+	earlier := now.Add(-time.Hour)
+	yesterday := now.Add(-25 * time.Hour)
+	var journal strings.Builder
+	for _, e := range []struct {
+		at time.Time
+		n  int
+	}{{yesterday, 5}, {earlier, 2}, {now, 1}} {
+		for i := 0; i < e.n; i++ {
+			journal.WriteString(e.at.Format(hourFmt) + " arxiv.org\n")
+		}
+	}
+	journal.WriteString(now.Format(hourFmt) + " arxiv.o") // cut off mid-write
+	if err := os.WriteFile(path+".log", []byte(journal.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := MakeLedger(path, Budget{Hourly: 10, Daily: 20})
+	h, d := remainingOf(t, l)
+	wantDaily := 20 - 1
+	if earlier.Format(dayFmt) == now.Format(dayFmt) {
+		wantDaily -= 2
+	}
+	if h != 9 || d != wantDaily {
+		t.Errorf("Remaining = %d, %d, want 9 this hour and %d today", h, d, wantDaily)
+	}
+}
+
+func TestRecordOverBudget(t *testing.T) {
+	path := testPath(t)
+	a := MakeLedger(path, Budget{Hourly: 3})
+	b := MakeLedger(path, Budget{Hourly: 3})
+	record(t, a, "arxiv.org", 2)
+	record(t, b, "arxiv.org", 1)
+	for _, l := range []*Ledger{a, b} {
+		if err := l.Record("arxiv.org"); !errors.Is(err, ErrBudgetExceeded) {
+			t.Errorf("Record over the hourly budget = %v, want ErrBudgetExceeded", err)
+		}
+	}
+	if n := journalLines(t, path); n != 3 {
+		t.Errorf("journal has %d lines, refused requests should not be counted", n)
+	}
+
+	c := MakeLedger(path, Budget{Hourly: 10, Daily: 4})
+	record(t, c, "arxiv.org", 1)
+	if err := c.Record("arxiv.org"); !errors.Is(err, ErrBudgetExceeded) || !strings.Contains(err.Error(), "today") {
+		t.Errorf("Record over the daily budget = %v, want ErrBudgetExceeded today", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	path := testPath(t)
+	l := MakeLedger(path, Budget{Hourly: 5, Daily: 8})
+	record(t, l, "arxiv.org", 2)
+	for _, tc := range []struct {
+		budget  Budget
+		planned int
+		want    string
+	}{
+		{budget: Budget{Hourly: 5, Daily: 8}, planned: 3},
+		{budget: Budget{Hourly: 5, Daily: 8}, planned: 4, want: "only 3 left this hour"},
+		{budget: Budget{Daily: 4}, planned: 3, want: "only 2 left today"},
+		{budget: Budget{}, planned: 1000000},
+	} {
+		err := MakeLedger(path, tc.budget).Check(tc.planned)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%+v Check(%d) = %v, want it to fit", tc.budget, tc.planned, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("%+v Check(%d) = %v, want %q", tc.budget, tc.planned, err, tc.want)
+		}
+	}
+}
+
+func TestCorruptLedger(t *testing.T) {
+	path := testPath(t)
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := MakeLedger(path, Budget{Hourly: 10})
+	record(t, l, "arxiv.org", 1)
+	if h, _ := remainingOf(t, l); h != 9 {
+		t.Errorf("hourly left = %d after starting over, want 9", h)
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Errorf("corrupt ledger not kept: %v", err)
+	}
+}
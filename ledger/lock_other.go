@@ -0,0 +1,31 @@
+//go:build !unix
+
+package ledger
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// locks older than this were left behind by a crashed process
+const staleLock = 30 * time.Second
+
+func lockFile(path string) (func(), error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		st, err := os.Stat(path)
+		if err == nil && time.Since(st.ModTime()) > staleLock {
+			os.Remove(path)
+			continue
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
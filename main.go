@@ -2,14 +2,23 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/benjaminchristie/go-arxiv-tree/api"
 	log "github.com/benjaminchristie/go-arxiv-tree/arxiv_logger"
+	"github.com/benjaminchristie/go-arxiv-tree/bus"
+	"github.com/benjaminchristie/go-arxiv-tree/events"
+	"github.com/benjaminchristie/go-arxiv-tree/export"
+	"github.com/benjaminchristie/go-arxiv-tree/ledger"
 	ratelimiter "github.com/benjaminchristie/go-arxiv-tree/rate_limiter"
+	"github.com/benjaminchristie/go-arxiv-tree/shutdown"
 	"github.com/benjaminchristie/go-arxiv-tree/tree"
 	"github.com/benjaminchristie/go-arxiv-tree/tui"
 )
@@ -17,35 +26,201 @@ import (
 func main() {
 	var id string
 	var depth int
-	var t *tree.ArxivTree
-	var info *tree.ArxivTreeInfo
 	var p api.QueryRequest
 	var err error
 
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
 	tuiPtr := flag.Bool("tui", false, "use tui")
 	dirPtr := flag.String("dir", "arxiv-download-folder", "directory to save pdfs to")
-	drawPtr := flag.String("viz-out", "", "file to output graph info to")
+	drawPtr := flag.String("viz-out", "", "file to draw the graph to, as SVG if it ends in .svg and as DOT otherwise")
+	vizColorPtr := flag.String("viz-color", tree.ColorDepth, "color -viz-out nodes by depth, category, status or none")
+	vizClusterPtr := flag.String("viz-cluster", tree.ClusterNone, "group -viz-out nodes by root, category or none")
+	vizSizePtr := flag.Bool("viz-size", true, "scale -viz-out nodes by how often the graph cites them")
+	vizCollapsePtr := flag.Int("viz-collapse", 0, "merge -viz-out leaves cited at most this many times")
 	auPtr := flag.Bool("author", false, "pass this flag to search by author")
 	tiPtr := flag.Bool("title", false, "pass this flag to search by title")
 	idPtr := flag.Bool("id", false, "pass this flag to search by id")
+	var seeds []string
+	flag.Func("seed", "an arXiv ID or title to start the crawl from, repeat for several", func(s string) error {
+		seeds = append(seeds, s)
+		return nil
+	})
+	seedsFilePtr := flag.String("seeds-file", "", "file of seeds to start the crawl from, one ID or title per line")
 	logPtr := flag.Bool("log", false, "pass this flag log to stdout")
 	safePtr := flag.Bool("safe", false, "pass this flag to enable safe mode (rate-limited)")
+	limitsPtr := flag.String("limits", "", "json file with per-host rate limits")
+	apiRatePtr := flag.Float64("api-rate", 0, "requests per second to "+api.API_HOST)
+	apiBurstPtr := flag.Int("api-burst", 0, "burst size for "+api.API_HOST+", 0 keeps the current one")
+	apiInFlightPtr := flag.Int("api-inflight", 0, "max concurrent requests to "+api.API_HOST)
+	dlRatePtr := flag.Float64("dl-rate", 0, "requests per second to "+api.DOWNLOAD_HOST)
+	dlBurstPtr := flag.Int("dl-burst", 0, "burst size for "+api.DOWNLOAD_HOST+", 0 keeps the current one")
+	dlInFlightPtr := flag.Int("dl-inflight", 0, "max concurrent requests to "+api.DOWNLOAD_HOST)
+	adaptivePtr := flag.Bool("adaptive", false, "slow down when arXiv pushes back, then probe back up")
+	ledgerPtr := flag.String("ledger", ledger.DefaultPath(), "request ledger shared by every run on this machine")
+	hourlyPtr := flag.Int("budget-hourly", 0, "max requests per hour across all runs sharing the ledger")
+	dailyPtr := flag.Int("budget-daily", 0, "max requests per day across all runs sharing the ledger")
+	logLevelPtr := flag.String("log-level", "info", "debug, info, warn or error")
+	logFormatPtr := flag.String("log-format", "text", "text or json")
+	logFilePtr := flag.String("log-file", "", "log file (default log.log, or tui.log with -tui)")
+	maxNodesPtr := flag.Int("max-nodes", 0, "stop the crawl once this many papers are in the graph")
+	maxRefsPtr := flag.Int("max-refs", 0, "follow at most this many references per paper")
+	deadlinePtr := flag.Duration("deadline", 0, "stop the crawl after this long")
+	resolveFilterPtr := flag.String("resolve-filter", "", "only take in references matching this filter, e.g. 'year:2015.. cat:cs.* -author:smith title:/graph/ matched'")
+	expandFilterPtr := flag.String("expand-filter", "", "only expand papers matching this filter, same syntax as -resolve-filter")
+	savePtr := flag.String("save", "", "write the crawled graph to this snapshot file")
+	loadPtr := flag.String("load", "", "read a snapshot file instead of crawling")
+	checkpointPtr := flag.String("checkpoint", "", "save the crawl state to this file as it goes, for -resume")
+	checkpointEveryPtr := flag.Duration("checkpoint-every", tree.DefaultCheckpointEvery, "how often to write the checkpoint")
+	resumePtr := flag.String("resume", "", "continue the crawl checkpointed in this file")
+	exportPtr := flag.String("export", "", "export the graph to this file, in the format its extension names")
+	exportFormatPtr := flag.String("export-format", "", "export format: "+strings.Join(export.Names(), ", "))
+	drainPtr := flag.Duration("drain", 10*time.Second, "how long to wait for in-flight work on shutdown")
 	flag.Parse()
 
+	if *seedsFilePtr != "" {
+		more, err := tree.ReadSeeds(*seedsFilePtr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read -seeds-file: %s\n", err)
+			os.Exit(1)
+		}
+		seeds = append(seeds, more...)
+	}
+
+	if _, ok := export.Lookup(*exportFormatPtr); *exportFormatPtr != "" && !ok {
+		fmt.Fprintf(os.Stderr, "Unknown -export-format %s, want one of %s\n", *exportFormatPtr, strings.Join(export.Names(), ", "))
+		os.Exit(1)
+	}
+	for name, expr := range map[string]string{"resolve-filter": *resolveFilterPtr, "expand-filter": *expandFilterPtr} {
+		if _, err := tree.ParseFilter(expr); err != nil {
+			fmt.Fprintf(os.Stderr, "Bad -%s: %s\n", name, err)
+			os.Exit(1)
+		}
+	}
+	vizOptions := tree.DOTOptions{
+		Color:           *vizColorPtr,
+		Cluster:         *vizClusterPtr,
+		SizeByCitations: *vizSizePtr,
+		CollapseLeaves:  *vizCollapsePtr,
+	}
+	if err := vizOptions.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Bad -viz-* flag: %s\n", err)
+		os.Exit(1)
+	}
+	out := outputs{
+		drawFile:     *drawPtr,
+		drawOptions:  vizOptions,
+		saveFile:     *savePtr,
+		exportFile:   *exportPtr,
+		exportFormat: *exportFormatPtr,
+	}
+
+	logConfig := log.Config{
+		Format:   *logFormatPtr,
+		Stdout:   *logPtr && !*tuiPtr,
+		Filename: *logFilePtr,
+	}
+	logConfig.Level, err = log.ParseLevel(*logLevelPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad -log-level %s: %s\n", *logLevelPtr, err)
+		os.Exit(1)
+	}
+	if logConfig.Filename == "" {
+		logConfig.Filename = "log.log"
+		if *tuiPtr {
+			logConfig.Filename = "tui.log"
+		}
+	}
+	err = log.Setup(logConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	coord := shutdown.MakeCoordinator(*drainPtr)
+	coord.OnShutdown("logs", func(context.Context) error {
+		return log.Close()
+	})
+	coord.OnShutdown("temp files", func(context.Context) error {
+		return tree.CleanupTemp()
+	})
+
+	requestLedger := ledger.MakeLedger(*ledgerPtr, ledger.Budget{
+		Hourly: *hourlyPtr,
+		Daily:  *dailyPtr,
+	})
+	api.SetLedger(requestLedger)
+
+	if *limitsPtr != "" {
+		fc, err := ratelimiter.LoadConfig(*limitsPtr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read %s: %s\n", *limitsPtr, err)
+			os.Exit(1)
+		}
+		ratelimiter.Apply(fc)
+	}
+	// flags left at 0 keep what -limits or the defaults set
+	if *apiRatePtr != 0 || *apiBurstPtr != 0 || *apiInFlightPtr != 0 {
+		ratelimiter.Tune(api.API_HOST, *apiRatePtr, *apiBurstPtr, *apiInFlightPtr)
+	}
+	if *dlRatePtr != 0 || *dlBurstPtr != 0 || *dlInFlightPtr != 0 {
+		ratelimiter.Tune(api.DOWNLOAD_HOST, *dlRatePtr, *dlBurstPtr, *dlInFlightPtr)
+	}
+	if *adaptivePtr {
+		ratelimiter.SetAdaptive(true)
+	}
+
 	if *tuiPtr {
-		log.Initialize(true, false, "tui.log")
-		t := tui.MakeTUI()
-		t.Run()
+		if *safePtr {
+			ratelimiter.Enable()
+		}
+		t := tui.MakeTUI(coord)
+		t.Ledger = requestLedger
+		if *loadPtr != "" {
+			err = t.Open(*loadPtr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+		}
+		coord.Listen()
+		os.Exit(coord.Run(func(ctx context.Context) error {
+			t.Run()
+			return nil
+		}))
+	} else if *resumePtr != "" {
+		if *safePtr {
+			ratelimiter.Enable()
+		}
+		g, err := tree.Load(*resumePtr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		// same limits as the interrupted crawl, checkpointing to the same file
+		crawlConfig := g.Config()
+		crawlConfig.Checkpoint = *resumePtr
+		crawlConfig.CheckpointEvery = *checkpointEveryPtr
+		fmt.Printf("Resuming %s: %d papers, %s spent so far\n", *resumePtr, g.Len(), g.Elapsed().Round(time.Second))
+		coord.Listen()
+		os.Exit(coord.Run(func(ctx context.Context) error {
+			return crawl(ctx, func(context.Context) (*tree.Graph, error) {
+				return g, nil
+			}, crawlConfig, *dirPtr, out)
+		}))
+	} else if *loadPtr != "" {
+		err = browse(*loadPtr, out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
 	} else {
 
 		if *safePtr {
 			ratelimiter.Enable()
 		}
-		if *logPtr {
-			log.Initialize(true, true, "log.log")
-		} else {
-			log.Initialize(true, false, "log.log")
-		}
 		scanner := bufio.NewScanner(os.Stdin)
 
 		fmt.Printf("--------------------------------------------------------------------\n")
@@ -53,7 +228,13 @@ func main() {
 			"or the arXiv API will reject your query. Pass -h for help info.\n")
 		fmt.Printf("--------------------------------------------------------------------\n")
 
-		if *auPtr {
+		if len(seeds) > 0 {
+			fmt.Printf("Crawling from %d seeds\n", len(seeds))
+			fmt.Printf("Enter max tree depth: ")
+			fmt.Scanf("%d", &depth)
+			log.Printf("searching from %d seeds with depth %d", len(seeds), depth)
+
+		} else if *auPtr {
 			fmt.Printf("Enter Author to search: ")
 			if scanner.Scan() {
 				id = scanner.Text()
@@ -99,28 +280,179 @@ func main() {
 			p.Title = id
 
 		}
-		err = tree.MakeInfoFromQuery(info, p, true)
+		crawlConfig := tree.CrawlConfig{
+			Depth:           depth,
+			MaxNodes:        *maxNodesPtr,
+			MaxRefs:         *maxRefsPtr,
+			Deadline:        *deadlinePtr,
+			Resolve:         *resolveFilterPtr,
+			Expand:          *expandFilterPtr,
+			Checkpoint:      *checkpointPtr,
+			CheckpointEvery: *checkpointEveryPtr,
+		}
+		err = requestLedger.Check(tree.EstimateRequests(crawlConfig, max(len(seeds), 1)))
 		if err != nil {
-			log.Fatal(err)
+			fmt.Printf("Warning: this crawl may exceed the request budget: %s\n", err)
+			log.Printf("budget warning: %s", err)
+		}
+
+		coord.Listen()
+		os.Exit(coord.Run(func(ctx context.Context) error {
+			return crawl(ctx, func(ctx context.Context) (*tree.Graph, error) {
+				ctx = ratelimiter.WithClass(ctx, ratelimiter.Interactive)
+				if len(seeds) > 0 {
+					return tree.MakeSeededGraph(ctx, seeds)
+				}
+				var info tree.Paper
+				err := tree.MakeInfoFromQueryContext(ctx, &info, p, true)
+				if err != nil {
+					return nil, err
+				}
+				return tree.MakeGraph(info), nil
+			}, crawlConfig, *dirPtr, out)
+		}))
+	}
+}
+
+// progress prints crawl events to stdout until the returned func is called
+func progress() func() {
+	sub := events.Bus.Subscribe(256, bus.DropOldest)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		nodes, pdfs, failed, filtered := 0, 0, 0, 0
+		for e := range sub.C {
+			switch e.(type) {
+			case events.NodeAdded:
+				nodes++
+			case events.PDFSaved:
+				pdfs++
+			case events.NodeFailed:
+				failed++
+			case events.NodeFiltered:
+				filtered++
+			default:
+				continue
+			}
+			fmt.Printf("[%d nodes, %d pdfs, %d failed, %d filtered] %s\n", nodes, pdfs, failed, filtered, e)
+		}
+	}()
+	return func() {
+		sub.Unsubscribe()
+		<-done
+	}
+}
+
+// outputs are the files a finished crawl or a loaded snapshot is written to.
+// Empty names are skipped.
+type outputs struct {
+	drawFile     string
+	drawOptions  tree.DOTOptions
+	saveFile     string
+	exportFile   string
+	exportFormat string // empty to go by the extension of exportFile
+}
+
+func (o outputs) write(g *tree.Graph) error {
+	var errs []error
+	if o.drawFile != "" {
+		if strings.EqualFold(filepath.Ext(o.drawFile), ".svg") {
+			log.Printf("Drawing graph to %s", o.drawFile)
+		} else {
+			log.Printf("Outputing graph view to %s. Run `dot -Tsvg %s -o <file>` to view, or name it .svg to skip Graphviz.", o.drawFile, o.drawFile)
+		}
+		errs = append(errs, export.Visualize(g, o.drawFile, o.drawOptions))
+	}
+	if o.saveFile != "" {
+		err := tree.Save(g, o.saveFile)
+		if err == nil {
+			fmt.Printf("Saved %d papers to %s\n", g.Len(), o.saveFile)
 		}
-		tree.PopulateTree(t, depth, func(at *tree.ArxivTree) {})
-		err = os.MkdirAll(*dirPtr, 0755)
+		errs = append(errs, err)
+	}
+	if o.exportFile != "" {
+		err := export.WriteFile(g, o.exportFile, o.exportFormat)
+		if err == nil {
+			fmt.Printf("Exported %d papers to %s\n", g.Len(), o.exportFile)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// crawl builds the graph start returns, a new one or a resumed one, and
+// downloads every PDF in it to dir. If a limit in c is hit part way,
+// whatever was crawled is still downloaded. If ctx is cancelled, downloads
+// stop too. Either way the graph so far is written to out.
+func crawl(ctx context.Context, start func(context.Context) (*tree.Graph, error), c tree.CrawlConfig, dir string, out outputs) error {
+	stop := progress()
+	defer stop()
+	g, err := start(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := out.write(g)
 		if err != nil {
-			log.Fatalf("Couldn't create directory %s", *dirPtr)
+			fmt.Printf("%s\n", err)
+			log.Printf("could not write outputs: %s", err)
+		}
+	}()
+	crawlErr := tree.Crawl(ctx, g, c, nil)
+	if crawlErr != nil && ctx.Err() == nil {
+		fmt.Printf("Crawl stopped early (%s) with %d papers, %d left unexpanded\n", crawlErr, g.Len(), len(g.Frontier()))
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("Couldn't create directory %s: %w", dir, err)
+	}
+	// every paper is downloaded once, however often it is cited
+	for _, n := range g.Nodes() {
+		if ctx.Err() != nil {
+			break
+		}
+		v := n.Info
+		err := api.DownloadPDFContext(ctx, n.ID, fmt.Sprintf("%s/%s_%s.pdf", dir, strings.Replace(v.Title, "/", "", -1), n.ID))
+		if err != nil && ctx.Err() == nil {
+			g.Fail("", v, n.Depth, "pdf", err)
 		}
+	}
+	return ctx.Err()
+}
+
+// browse prints a saved graph as an indented tree, and writes it to out,
+// without touching the network
+func browse(filename string, out outputs) error {
+	g, err := tree.Load(filename)
+	if err != nil {
+		return err
+	}
+	c := g.Config()
+	fmt.Printf("%s: %d papers, %d citations, %d failures, %d filtered (depth %d)\n",
+		filename, g.Len(), len(g.Edges()), len(g.Failures()), len(g.Filtered()), c.Depth)
+	for _, t := range g.Trees() {
 		tree.Traverse(t, func(n *tree.ArxivTree) {
-			v := n.Value.(tree.ArxivTreeInfo)
-			if v.ID != "" {
-				log.Printf("Downloading PDF: %.20s: %.60s", v.Author, v.Title)
-				api.DownloadPDF(v.ID, fmt.Sprintf("%s/%s_%s.pdf", *dirPtr, strings.Replace(v.Title, "/", "", -1), v.ID))
-			} else {
-				log.Printf("Could not download PDF, n.Info.ID is empty")
-			}
+			fmt.Printf("%s%s  %.70s\n", strings.Repeat("  ", n.Depth()), n.Paper.ID, n.Paper.Title)
 		})
-
-		if *drawPtr != "" {
-			log.Printf("Outputing graph view to %s. Run `dot -Tsvg %s -o <file>` to view.", *drawPtr, *drawPtr)
-			tree.Visualize(t, *drawPtr)
+	}
+	if seeds := g.Seeds(); len(seeds) > 1 {
+		shared := g.Overlap()
+		fmt.Printf("\n%d seeds, %d papers reached from more than one:\n", len(seeds), len(shared))
+		for _, n := range shared {
+			var from []string
+			for _, s := range seeds {
+				if d, ok := n.Seeds[s]; ok {
+					from = append(from, fmt.Sprintf("%s (%d)", s, d))
+				}
+			}
+			fmt.Printf("  %-10s %.50q from %s\n", n.ID, n.Info.Title, strings.Join(from, ", "))
+		}
+	}
+	if f := g.Filtered(); len(f) > 0 {
+		fmt.Printf("\nFiltered by %q (resolve) and %q (expand):\n", c.Resolve, c.Expand)
+		for _, f := range f {
+			fmt.Printf("  %-7s %-10s %.50q: %s\n", f.Stage, f.ID, f.Title, f.Reason)
 		}
 	}
+	return out.write(g)
 }
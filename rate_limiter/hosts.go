@@ -0,0 +1,188 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// arXiv asks for no more than one request every three seconds
+const DefaultPeriod = 3 * time.Second
+
+// FileConfig is the on-disk format read by LoadConfig, e.g.
+//
+//	{
+//	  "default": {"rate": 0.33, "burst": 1, "adaptive": true},
+//	  "hosts": {"arxiv.org": {"rate": 1, "burst": 4, "max_in_flight": 2}}
+//	}
+type FileConfig struct {
+	Default *Config           `json:"default,omitempty"`
+	Hosts   map[string]Config `json:"hosts,omitempty"`
+}
+
+var enabled bool
+var lock sync.Mutex
+var Default *Limiter
+var hosts map[string]*Limiter
+
+func init() {
+	enabled = false
+	Default = MakeLimiter(Config{Rate: 1 / DefaultPeriod.Seconds(), Burst: 1})
+	Default.name = "default"
+	hosts = make(map[string]*Limiter)
+}
+
+// SetHost configures the limiter used for requests to host, creating it if
+// needed. Hosts with their own limiter are limited whether or not the
+// default instance is enabled.
+func SetHost(host string, c Config) *Limiter {
+	lock.Lock()
+	defer lock.Unlock()
+	l, ok := hosts[host]
+	if ok {
+		l.SetConfig(c)
+		return l
+	}
+	l = MakeLimiter(c)
+	l.name = host
+	hosts[host] = l
+	return l
+}
+
+// Tune changes the rate, burst and in-flight cap of the limiter for host,
+// starting from the config of the default instance if host has no limiter
+// yet. Zero values keep the setting in effect, so capping concurrency alone
+// keeps the rate arXiv asks for.
+func Tune(host string, rate float64, burst, maxInFlight int) *Limiter {
+	lock.Lock()
+	l, ok := hosts[host]
+	lock.Unlock()
+	c := Default.Config()
+	if ok {
+		c = l.Config()
+	}
+	if rate != 0 {
+		c.Rate = rate
+	}
+	if burst != 0 {
+		c.Burst = burst
+	}
+	if maxInFlight != 0 {
+		c.MaxInFlight = maxInFlight
+	}
+	return SetHost(host, c)
+}
+
+// ForHost returns the limiter for host, the default instance if host has no
+// limiter of its own and limiting is enabled, or nil otherwise.
+func ForHost(host string) *Limiter {
+	lock.Lock()
+	defer lock.Unlock()
+	l, ok := hosts[host]
+	if ok {
+		return l
+	}
+	if enabled {
+		return Default
+	}
+	return nil
+}
+
+// SetAdaptive turns adaptive limiting on or off for the default instance and
+// every host limiter.
+func SetAdaptive(b bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, l := range append([]*Limiter{Default}, values(hosts)...) {
+		c := l.Config()
+		c.Adaptive = b
+		l.SetConfig(c)
+	}
+}
+
+// IsAdaptive reports whether the default instance is adaptive, as SetAdaptive
+// last left it
+func IsAdaptive() bool {
+	return Default.Config().Adaptive
+}
+
+func values(m map[string]*Limiter) []*Limiter {
+	v := make([]*Limiter, 0, len(m))
+	for _, l := range m {
+		v = append(v, l)
+	}
+	return v
+}
+
+func Hosts() map[string]Config {
+	lock.Lock()
+	defer lock.Unlock()
+	m := make(map[string]Config, len(hosts))
+	for h, l := range hosts {
+		m[h] = l.Config()
+	}
+	return m
+}
+
+func LoadConfig(filename string) (FileConfig, error) {
+	var fc FileConfig
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return fc, err
+	}
+	err = json.Unmarshal(b, &fc)
+	return fc, err
+}
+
+// Apply installs every limiter in fc. A default entry also enables the
+// default instance.
+func Apply(fc FileConfig) {
+	if fc.Default != nil {
+		Default.SetConfig(*fc.Default)
+		Enable()
+	}
+	for h, c := range fc.Hosts {
+		SetHost(h, c)
+	}
+}
+
+func Enable() {
+	lock.Lock()
+	defer lock.Unlock()
+	enabled = true
+}
+
+// Disable stops limiting hosts without a limiter of their own
+func Disable() {
+	lock.Lock()
+	defer lock.Unlock()
+	enabled = false
+}
+
+func IsEnabled() bool {
+	lock.Lock()
+	defer lock.Unlock()
+	return enabled
+}
+
+func WaitIfEnabled() {
+	if IsEnabled() {
+		Wait()
+	}
+}
+
+// Wait takes a slot from the default instance, enabling it if necessary.
+func Wait() {
+	Enable()
+	Default.Wait(context.Background(), Metadata)
+	Default.Done()
+}
+
+// Reset changes the period of the default instance.
+func Reset(d time.Duration) {
+	c := Default.Config()
+	c.Rate = 1 / d.Seconds()
+	Default.SetConfig(c)
+}
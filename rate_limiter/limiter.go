@@ -1,51 +1,257 @@
 package ratelimiter
 
 import (
+	"context"
+	"net/http"
 	"sync"
 	"time"
+
+	log "github.com/benjaminchristie/go-arxiv-tree/arxiv_logger"
 )
 
-var enabled bool
-var lock sync.Mutex
-var ticker *time.Ticker
+// Config describes a token bucket. Rate is in requests per second and a
+// Rate <= 0 means the bucket never runs dry. MaxInFlight <= 0 means there
+// is no cap on concurrent requests.
+//
+// An Adaptive limiter treats Rate as a ceiling. It backs off when Observe
+// reports pushback from the server and probes back up towards Rate after a
+// run of successes, never dropping below MinRate.
+type Config struct {
+	Rate        float64 `json:"rate"`
+	Burst       int     `json:"burst"`
+	MaxInFlight int     `json:"max_in_flight"`
+	Adaptive    bool    `json:"adaptive"`
+	MinRate     float64 `json:"min_rate"`
+}
 
-func init() {
-	enabled = false
+// Outcome is reported to an adaptive limiter after each request
+type Outcome struct {
+	Status     int
+	RetryAfter time.Duration
+	Empty      bool // an empty Atom feed where a result was certain, arXiv's soft-ban signal
 }
 
-func Enable() <-chan time.Time {
-	lock.Lock()
-	defer lock.Unlock()
-	ticker = time.NewTicker(3 * time.Second)
-	enabled = true
-	return ticker.C
+const (
+	defaultMinRate = 1.0 / 60
+	backoffFactor  = 0.5
+	probeFactor    = 1.25
+	probeAfter     = 20 // consecutive successes before raising the rate
+	unlimitedAbove = 10 // an unlimited limiter that probes past this is unlimited again
+)
+
+var logger = log.Logger("ratelimiter")
+
+type Limiter struct {
+	lock        sync.Mutex
+	name        string
+	config      Config
+	rate        float64 // current rate, below config.Rate while backing off
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	successes   int
+	inFlight    int
+	released    chan bool // closed and replaced whenever capacity frees up
+	queues      [numClasses][]*waiter
+	served      [numClasses]int // slots handed out this round
 }
 
-func IsEnabled() bool {
-	return enabled
+func MakeLimiter(c Config) *Limiter {
+	l := &Limiter{
+		name:     "limiter",
+		released: make(chan bool),
+	}
+	l.SetConfig(c)
+	return l
 }
 
-func WaitIfEnabled() {
-	if enabled {
-		<-ticker.C
+func (l *Limiter) burst() float64 {
+	if l.config.Burst < 1 {
+		return 1
 	}
+	return float64(l.config.Burst)
 }
 
-func Wait() {
-	if !enabled {
-		Enable()
+// must hold l.lock
+func (l *Limiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
 	}
-	_, ok := <-ticker.C
-	go func() {
-		if !ok {
-			ticker.Reset(3 * time.Second)
+	if l.tokens > l.burst() {
+		l.tokens = l.burst()
+	}
+	l.last = now
+}
+
+// must hold l.lock
+func (l *Limiter) wake() {
+	close(l.released)
+	l.released = make(chan bool)
+	notify()
+}
+
+func (l *Limiter) SetConfig(c Config) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	if l.last.IsZero() {
+		l.config = c
+		l.rate = c.Rate
+		l.tokens = l.burst()
+		l.last = now
+	} else {
+		l.refill(now)
+		l.config = c
+		l.rate = c.Rate
+		if l.tokens > l.burst() {
+			l.tokens = l.burst()
 		}
-	}()
+	}
+	l.successes = 0
+	l.wake()
+}
+
+// Rate returns the rate currently in effect
+func (l *Limiter) Rate() float64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.rate
+}
+
+func (l *Limiter) minRate() float64 {
+	if l.config.MinRate > 0 {
+		return l.config.MinRate
+	}
+	return defaultMinRate
 }
 
-func Reset(d time.Duration) <-chan time.Time {
-	lock.Lock()
-	defer lock.Unlock()
-	ticker.Reset(d)
-	return ticker.C
+// must hold l.lock
+func (l *Limiter) setRate(rate float64, reason string) {
+	if rate == l.rate {
+		return
+	}
+	logger.Info("rate changed", "limiter", l.name, "from", l.rate, "to", rate, "reason", reason)
+	l.refill(time.Now())
+	l.rate = rate
+	l.wake()
+}
+
+// Observe adjusts an adaptive limiter based on the outcome of a request.
+// Non-adaptive limiters ignore it.
+func (l *Limiter) Observe(o Outcome) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.config.Adaptive {
+		return
+	}
+	reason := ""
+	switch {
+	case o.Status == http.StatusTooManyRequests:
+		reason = "429 Too Many Requests"
+	case o.Status == http.StatusServiceUnavailable:
+		reason = "503 Service Unavailable"
+	case o.RetryAfter > 0:
+		reason = "Retry-After"
+	case o.Empty:
+		reason = "empty feed"
+	}
+	if reason == "" {
+		l.successes++
+		if l.successes < probeAfter || l.rate <= 0 || l.rate == l.config.Rate {
+			return
+		}
+		l.successes = 0
+		rate := l.rate * probeFactor
+		if l.config.Rate > 0 {
+			rate = min(rate, l.config.Rate)
+		} else if rate >= unlimitedAbove {
+			rate = 0
+		}
+		l.setRate(rate, "probing after successes")
+		return
+	}
+	l.successes = 0
+	if o.RetryAfter > 0 {
+		until := time.Now().Add(o.RetryAfter)
+		if until.After(l.pausedUntil) {
+			logger.Warn("pausing", "limiter", l.name, log.Duration(o.RetryAfter), "reason", reason)
+			l.pausedUntil = until
+		}
+	}
+	rate := l.rate
+	if rate <= 0 {
+		// unlimited until now, start from the rate arXiv asks for
+		rate = 1 / DefaultPeriod.Seconds()
+	} else {
+		rate *= backoffFactor
+	}
+	if o.RetryAfter > 0 {
+		rate = min(rate, 1/o.RetryAfter.Seconds())
+	}
+	l.setRate(max(rate, l.minRate()), reason)
+}
+
+func (l *Limiter) Config() Config {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.config
+}
+
+// Wait blocks until it is the caller's turn within class and a token and
+// an in-flight slot are both available, or ctx is done. Every successful
+// Wait must be paired with a call to Done once the request has finished.
+func (l *Limiter) Wait(ctx context.Context, class Class) error {
+	w := &waiter{class: class}
+	l.lock.Lock()
+	l.enqueue(w)
+	l.lock.Unlock()
+	notify()
+	for {
+		l.lock.Lock()
+		now := time.Now()
+		l.refill(now)
+		turn := l.next() == w
+		paused := now.Before(l.pausedUntil)
+		slotFree := l.config.MaxInFlight <= 0 || l.inFlight < l.config.MaxInFlight
+		if turn && !paused && slotFree && (l.rate <= 0 || l.tokens >= 1) {
+			if l.rate > 0 {
+				l.tokens--
+			}
+			l.inFlight++
+			l.served[class]++
+			l.dequeue(w)
+			l.wake() // the next waiter may be able to go right away
+			l.lock.Unlock()
+			return nil
+		}
+		var timer <-chan time.Time
+		if turn && paused {
+			timer = time.After(l.pausedUntil.Sub(now))
+		} else if turn && slotFree {
+			d := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+			timer = time.After(d)
+		}
+		released := l.released
+		l.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			l.lock.Lock()
+			l.dequeue(w)
+			l.wake()
+			l.lock.Unlock()
+			return ctx.Err()
+		case <-timer:
+		case <-released:
+		}
+	}
+}
+
+func (l *Limiter) Done() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.inFlight > 0 {
+		l.inFlight--
+	}
+	l.wake()
 }
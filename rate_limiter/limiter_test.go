@@ -0,0 +1,229 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// waitFor takes a slot from l or fails the test after d
+func waitFor(t *testing.T, l *Limiter, d time.Duration) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	if err := l.Wait(ctx, Metadata); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+}
+
+func TestBurstThenRate(t *testing.T) {
+	l := MakeLimiter(Config{Rate: 20, Burst: 3})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		waitFor(t, l, 10*time.Millisecond)
+		l.Done()
+	}
+	if d := time.Since(start); d > 20*time.Millisecond {
+		t.Errorf("burst of 3 took %s, want no wait", d)
+	}
+	waitFor(t, l, time.Second)
+	l.Done()
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("fourth request after %s, want about 50ms at 20/s", d)
+	}
+}
+
+func TestUnlimitedRate(t *testing.T) {
+	l := MakeLimiter(Config{})
+	for i := 0; i < 100; i++ {
+		waitFor(t, l, 10*time.Millisecond)
+		l.Done()
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	l := MakeLimiter(Config{MaxInFlight: 1})
+	waitFor(t, l, 10*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, Metadata); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second Wait with the slot taken = %v, want a timeout", err)
+	}
+	got := make(chan error)
+	go func() { got <- l.Wait(context.Background(), Metadata) }()
+	time.Sleep(10 * time.Millisecond)
+	l.Done()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Done did not hand the slot on")
+	}
+	l.Done()
+}
+
+func TestWaitCancelledLeavesQueue(t *testing.T) {
+	l := MakeLimiter(Config{Rate: 0.001, Burst: 1})
+	waitFor(t, l, 10*time.Millisecond)
+	l.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := l.Wait(ctx, Bulk); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait = %v, want context.Canceled", err)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for c, q := range l.queues {
+		if len(q) != 0 {
+			t.Errorf("%d waiters left in the %s queue", len(q), Class(c))
+		}
+	}
+}
+
+func TestSetConfigCapsTokens(t *testing.T) {
+	l := MakeLimiter(Config{Rate: 1, Burst: 10})
+	l.SetConfig(Config{Rate: 1, Burst: 2})
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.tokens > 2 {
+		t.Errorf("tokens = %v after lowering the burst to 2", l.tokens)
+	}
+}
+
+func TestTuneKeepsRate(t *testing.T) {
+	host := fmt.Sprintf("tune-%d.example.org", time.Now().UnixNano()) // hosts are global
+	l := Tune(host, 0, 0, 2)
+	c := l.Config()
+	if want := 1 / DefaultPeriod.Seconds(); c.Rate != want || c.MaxInFlight != 2 {
+		t.Fatalf("capping in-flight gave %+v, want rate %v and 2 in flight", c, want)
+	}
+	c = Tune(host, 5, 0, 0).Config()
+	if c.Rate != 5 || c.MaxInFlight != 2 {
+		t.Errorf("changing the rate gave %+v, want rate 5 and 2 in flight kept", c)
+	}
+	if ForHost(host) != l {
+		t.Errorf("Tune made a second limiter for %s", host)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	ok := func(n int) []Outcome {
+		return make([]Outcome, n)
+	}
+	adaptive := Config{Rate: 2, Adaptive: true}
+	for _, tc := range []struct {
+		name     string
+		config   Config
+		outcomes []Outcome
+		want     float64
+		paused   bool
+	}{
+		{name: "429 halves", config: adaptive, outcomes: []Outcome{{Status: 429}}, want: 1},
+		{name: "503 halves", config: adaptive, outcomes: []Outcome{{Status: 503}}, want: 1},
+		{name: "empty feed halves", config: adaptive, outcomes: []Outcome{{Empty: true}}, want: 1},
+		{name: "200 keeps the rate", config: adaptive, outcomes: []Outcome{{Status: 200}}, want: 2},
+		{
+			name:     "Retry-After pauses and caps the rate",
+			config:   adaptive,
+			outcomes: []Outcome{{Status: 429, RetryAfter: 10 * time.Second}},
+			want:     0.1,
+			paused:   true,
+		},
+		{
+			name:     "a short Retry-After pauses but only halves",
+			config:   adaptive,
+			outcomes: []Outcome{{RetryAfter: time.Second}},
+			want:     1,
+			paused:   true,
+		},
+		{
+			name:     "probes up after 20 successes",
+			config:   adaptive,
+			outcomes: append([]Outcome{{Status: 429}}, ok(20)...),
+			want:     1.25,
+		},
+		{
+			name:     "19 successes are not enough",
+			config:   adaptive,
+			outcomes: append([]Outcome{{Status: 429}}, ok(19)...),
+			want:     1,
+		},
+		{
+			name:     "a failure restarts the count",
+			config:   adaptive,
+			outcomes: append(append([]Outcome{{Status: 429}}, ok(19)...), append([]Outcome{{Status: 503}}, ok(19)...)...),
+			want:     0.5,
+		},
+		{
+			name:     "probing stops at the configured rate",
+			config:   adaptive,
+			outcomes: append([]Outcome{{Status: 429}}, ok(100)...),
+			want:     2,
+		},
+		{
+			name:     "non-adaptive limiters ignore Observe",
+			config:   Config{Rate: 2},
+			outcomes: []Outcome{{Status: 429, RetryAfter: 10 * time.Second}},
+			want:     2,
+		},
+		{
+			name:     "MinRate is a floor",
+			config:   Config{Rate: 2, Adaptive: true, MinRate: 0.8},
+			outcomes: []Outcome{{Status: 429}, {Status: 429}, {Status: 429}},
+			want:     0.8,
+		},
+		{
+			name:     "the default floor is one a minute",
+			config:   Config{Rate: 0.02, Adaptive: true},
+			outcomes: []Outcome{{Status: 429}},
+			want:     defaultMinRate,
+		},
+		{
+			name:     "unlimited starts backing off from one per period",
+			config:   Config{Adaptive: true},
+			outcomes: []Outcome{{Status: 429}},
+			want:     1 / DefaultPeriod.Seconds(),
+		},
+		{
+			name:     "unlimited keeps halving",
+			config:   Config{Adaptive: true},
+			outcomes: []Outcome{{Status: 429}, {Status: 429}},
+			want:     0.5 / DefaultPeriod.Seconds(),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := MakeLimiter(tc.config)
+			for _, o := range tc.outcomes {
+				l.Observe(o)
+			}
+			if got := l.Rate(); math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("rate = %v, want %v", got, tc.want)
+			}
+			l.lock.Lock()
+			paused := l.pausedUntil.After(time.Now())
+			l.lock.Unlock()
+			if paused != tc.paused {
+				t.Errorf("paused = %v, want %v", paused, tc.paused)
+			}
+		})
+	}
+}
+
+func TestObserveUnlimitedProbesBack(t *testing.T) {
+	l := MakeLimiter(Config{Adaptive: true})
+	l.Observe(Outcome{Status: 429})
+	for i := 0; i < 20*20 && l.Rate() != 0; i++ {
+		l.Observe(Outcome{})
+	}
+	if r := l.Rate(); r != 0 {
+		t.Errorf("rate = %v after a long run of successes, want unlimited again", r)
+	}
+}
@@ -0,0 +1,84 @@
+package ratelimiter
+
+import (
+	"context"
+)
+
+// Class orders requests waiting on the same limiter. Waiters are served
+// first-in first-out within a class. Across classes, slots are handed out
+// by weighted round robin, so bulk downloads keep trickling through while
+// interactive requests jump the queue.
+type Class int
+
+const (
+	Interactive Class = iota // searches a user is waiting on
+	Metadata                 // reference lookups and source downloads
+	Bulk                     // PDF downloads
+	numClasses
+)
+
+// slots per round each class gets while every class has waiters
+var weights = [numClasses]int{8, 4, 1}
+
+func (c Class) String() string {
+	switch c {
+	case Interactive:
+		return "interactive"
+	case Metadata:
+		return "metadata"
+	case Bulk:
+		return "bulk"
+	}
+	return "unknown"
+}
+
+type classKey struct{}
+
+// WithClass returns a copy of ctx that makes the api layer queue its
+// requests under class c.
+func WithClass(ctx context.Context, c Class) context.Context {
+	return context.WithValue(ctx, classKey{}, c)
+}
+
+// ClassFromContext returns the class stored by WithClass, or def.
+func ClassFromContext(ctx context.Context, def Class) Class {
+	c, ok := ctx.Value(classKey{}).(Class)
+	if !ok {
+		return def
+	}
+	return c
+}
+
+type waiter struct {
+	class Class
+}
+
+// must hold l.lock
+func (l *Limiter) enqueue(w *waiter) {
+	l.queues[w.class] = append(l.queues[w.class], w)
+}
+
+// must hold l.lock
+func (l *Limiter) dequeue(w *waiter) {
+	q := l.queues[w.class]
+	for i, e := range q {
+		if e == w {
+			l.queues[w.class] = append(q[:i], q[i+1:]...)
+			return
+		}
+	}
+}
+
+// next returns the waiter that should get the next slot. must hold l.lock
+func (l *Limiter) next() *waiter {
+	for round := 0; round < 2; round++ {
+		for c, q := range l.queues {
+			if len(q) > 0 && l.served[c] < weights[c] {
+				return q[0]
+			}
+		}
+		// every class with waiters has used up its share, start a new round
+		l.served = [numClasses]int{}
+	}
+	return nil
+}
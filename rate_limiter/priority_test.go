@@ -0,0 +1,111 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWeightedRoundRobin(t *testing.T) {
+	l := MakeLimiter(Config{})
+	ids := map[*waiter]string{}
+	for c := Interactive; c < numClasses; c++ {
+		for i := 0; i < 20; i++ {
+			w := &waiter{class: c}
+			ids[w] = c.String()
+			l.enqueue(w)
+		}
+	}
+	served := map[string]int{}
+	for i := 0; i < 2*(8+4+1); i++ {
+		w := l.next()
+		served[ids[w]]++
+		l.served[w.class]++
+		l.dequeue(w)
+	}
+	want := map[string]int{"interactive": 16, "metadata": 8, "bulk": 2}
+	for c, n := range want {
+		if served[c] != n {
+			t.Errorf("two rounds served %d %s waiters, want %d", served[c], c, n)
+		}
+	}
+}
+
+func TestFIFOWithinClass(t *testing.T) {
+	l := MakeLimiter(Config{})
+	var order []*waiter
+	for i := 0; i < 5; i++ {
+		w := &waiter{class: Bulk}
+		order = append(order, w)
+		l.enqueue(w)
+	}
+	l.dequeue(order[2]) // a cancelled waiter
+	for _, want := range append(order[:2:2], order[3:]...) {
+		w := l.next()
+		if w != want {
+			t.Fatalf("served waiter %p, want %p", w, want)
+		}
+		l.served[w.class]++
+		l.dequeue(w)
+	}
+	if w := l.next(); w != nil {
+		t.Errorf("next on empty queues = %p, want nil", w)
+	}
+}
+
+func TestNextStartsNewRound(t *testing.T) {
+	l := MakeLimiter(Config{})
+	l.served = [numClasses]int{Interactive: weights[Interactive]}
+	w := &waiter{class: Interactive}
+	l.enqueue(w)
+	// only interactive waiters, so their used-up share must not stall them
+	if l.next() != w {
+		t.Fatal("a lone class that used up its share was not served")
+	}
+}
+
+func TestInteractiveJumpsQueue(t *testing.T) {
+	l := MakeLimiter(Config{MaxInFlight: 1})
+	if err := l.Wait(context.Background(), Bulk); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan Class, 2)
+	wait := func(c Class) {
+		if err := l.Wait(context.Background(), c); err == nil {
+			got <- c
+			l.Done()
+		}
+	}
+	queued := func(n int) {
+		for i := 0; i < 100; i++ {
+			l.lock.Lock()
+			total := len(l.queues[Bulk]) + len(l.queues[Interactive])
+			l.lock.Unlock()
+			if total == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("waiters never queued")
+	}
+	go wait(Bulk)
+	queued(1)
+	go wait(Interactive)
+	queued(2)
+	// the bulk slot used up bulk's share of the round
+	l.Done()
+	if c := <-got; c != Interactive {
+		t.Errorf("%s waiter served first, want interactive", c)
+	}
+	<-got
+}
+
+func TestClassFromContext(t *testing.T) {
+	ctx := context.Background()
+	if c := ClassFromContext(ctx, Bulk); c != Bulk {
+		t.Errorf("ClassFromContext without a class = %s, want the default", c)
+	}
+	if c := ClassFromContext(WithClass(ctx, Interactive), Bulk); c != Interactive {
+		t.Errorf("ClassFromContext = %s, want interactive", c)
+	}
+}
@@ -0,0 +1,108 @@
+package ratelimiter
+
+import (
+	"sort"
+	"time"
+
+	"github.com/benjaminchristie/go-arxiv-tree/bus"
+)
+
+type LimiterStatus struct {
+	Name     string
+	Rate     float64 // 0 when unlimited
+	Queued   map[Class]int
+	InFlight int
+	NextSlot time.Duration // until the next token, or the end of a pause
+}
+
+type Status struct {
+	Time     time.Time
+	Limiters []LimiterStatus // the default instance first, then by host
+	Requests map[string]int  // requests made per host since start
+}
+
+// coalesce bursts of changes into at most one published Status per interval
+const publishInterval = 100 * time.Millisecond
+
+var requests map[string]int
+var changed chan bool
+
+// Updates carries limiter changes, coalesced to at most one per
+// publishInterval
+var Updates = bus.MakeTopic[Status]()
+
+func init() {
+	requests = make(map[string]int)
+	changed = make(chan bool, 1)
+	go publish()
+}
+
+func (l *Limiter) Status() LimiterStatus {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.refill(now)
+	s := LimiterStatus{
+		Name:     l.name,
+		Rate:     l.rate,
+		Queued:   make(map[Class]int, numClasses),
+		InFlight: l.inFlight,
+	}
+	for c, q := range l.queues {
+		s.Queued[Class(c)] = len(q)
+	}
+	if l.rate > 0 && l.tokens < 1 {
+		s.NextSlot = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	}
+	if now.Before(l.pausedUntil) {
+		s.NextSlot = max(s.NextSlot, l.pausedUntil.Sub(now))
+	}
+	return s
+}
+
+// CountRequest records a request to host in the per-host totals
+func CountRequest(host string) {
+	lock.Lock()
+	requests[host]++
+	lock.Unlock()
+	notify()
+}
+
+func Snapshot() Status {
+	lock.Lock()
+	names := make([]string, 0, len(hosts))
+	for h := range hosts {
+		names = append(names, h)
+	}
+	sort.Strings(names)
+	limiters := []*Limiter{Default}
+	for _, h := range names {
+		limiters = append(limiters, hosts[h])
+	}
+	s := Status{
+		Time:     time.Now(),
+		Requests: make(map[string]int, len(requests)),
+	}
+	for h, n := range requests {
+		s.Requests[h] = n
+	}
+	lock.Unlock()
+	for _, l := range limiters {
+		s.Limiters = append(s.Limiters, l.Status())
+	}
+	return s
+}
+
+func notify() {
+	select {
+	case changed <- true:
+	default:
+	}
+}
+
+func publish() {
+	for range changed {
+		Updates.Publish(Snapshot())
+		time.Sleep(publishInterval)
+	}
+}
@@ -0,0 +1,161 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/benjaminchristie/go-arxiv-tree/arxiv_logger"
+)
+
+// exit codes returned by Run
+const (
+	ExitOK         = 0
+	ExitError      = 1
+	ExitInterrupt  = 130 // 128 + SIGINT
+	ExitTerminated = 143 // 128 + SIGTERM
+)
+
+var logger = log.Logger("shutdown")
+
+type hook struct {
+	name string
+	fn   func(context.Context) error
+}
+
+// Coordinator owns the process lifetime. The first SIGINT or SIGTERM
+// cancels the context handed to workers, which are then given Drain to
+// finish before the shutdown hooks run. A second signal exits immediately.
+type Coordinator struct {
+	Drain  time.Duration
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	lock   sync.Mutex
+	hooks  []hook
+	code   int
+}
+
+func MakeCoordinator(drain time.Duration) *Coordinator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Coordinator{
+		Drain:  drain,
+		ctx:    ctx,
+		cancel: cancel,
+		code:   ExitOK,
+	}
+}
+
+// Context is cancelled as soon as shutdown begins
+func (c *Coordinator) Context() context.Context {
+	return c.ctx
+}
+
+// Go runs fn as a worker that shutdown waits for. Once shutdown has begun
+// it refuses new work and reports false.
+func (c *Coordinator) Go(fn func(ctx context.Context)) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.ctx.Err() != nil {
+		return false
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		fn(c.ctx)
+	}()
+	return true
+}
+
+// OnShutdown registers fn to run once workers have drained. Hooks run in
+// the reverse order they were registered, so the logger registered first
+// is closed last.
+func (c *Coordinator) OnShutdown(name string, fn func(context.Context) error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.hooks = append(c.hooks, hook{name: name, fn: fn})
+}
+
+// Stop begins shutdown with code unless it has already begun
+func (c *Coordinator) Stop(code int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.ctx.Err() == nil {
+		c.code = code
+	}
+	// under the lock, so no Go call slips in between the cancellation
+	// and the drain
+	c.cancel()
+}
+
+// Listen turns SIGINT and SIGTERM into calls to Stop
+func (c *Coordinator) Listen() {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		code := ExitInterrupt
+		if s == syscall.SIGTERM {
+			code = ExitTerminated
+		}
+		logger.Info("caught signal, shutting down", "signal", s.String(), log.Duration(c.Drain))
+		c.Stop(code)
+		<-sig
+		logger.Warn("caught second signal, exiting now")
+		os.Exit(code)
+	}()
+}
+
+// Run runs main as a worker, waits for it or for a signal, drains the
+// remaining workers and runs the shutdown hooks. It returns the exit code
+// the process should use.
+func (c *Coordinator) Run(main func(ctx context.Context) error) int {
+	c.Go(func(ctx context.Context) {
+		err := main(ctx)
+		switch {
+		case err == nil:
+			c.Stop(ExitOK)
+		case errors.Is(err, context.Canceled):
+			c.Stop(ExitInterrupt)
+		default:
+			logger.Error("exiting on error", log.Err(err))
+			c.Stop(ExitError)
+		}
+	})
+	<-c.ctx.Done()
+
+	drained := make(chan bool)
+	go func() {
+		c.wg.Wait()
+		close(drained)
+	}()
+	deadline, cancel := context.WithTimeout(context.Background(), c.Drain)
+	defer cancel()
+	select {
+	case <-drained:
+	case <-deadline.Done():
+		logger.Warn("workers did not drain in time", log.Duration(c.Drain))
+	}
+
+	c.lock.Lock()
+	hooks := c.hooks
+	code := c.code
+	c.lock.Unlock()
+	// hooks get a deadline of their own, the drain may have used up the first
+	flush, cancelFlush := context.WithTimeout(context.Background(), c.Drain)
+	defer cancelFlush()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		err := hooks[i].fn(flush)
+		if err != nil {
+			logger.Error("shutdown hook failed", "hook", hooks[i].name, log.Err(err))
+			if code == ExitOK {
+				code = ExitError
+			}
+		}
+	}
+	return code
+}
@@ -0,0 +1,114 @@
+package tree
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/benjaminchristie/go-arxiv-tree/arxiv_logger"
+)
+
+// DefaultCheckpointEvery is how often a crawl writes its checkpoint when
+// CrawlConfig.Checkpoint is set but CheckpointEvery is not
+const DefaultCheckpointEvery = 30 * time.Second
+
+// CheckpointState is what a snapshot written by a running crawl adds, so
+// that Resume can carry on where it stopped
+type CheckpointState struct {
+	Elapsed time.Duration `json:"elapsed"` // crawl time so far, counted against the deadline
+	Done    bool          `json:"done"`    // the crawl finished rather than being stopped
+}
+
+type checkpointer struct {
+	lock  sync.Mutex
+	g     *Graph
+	file  string
+	every time.Duration
+	last  time.Time
+	start time.Time
+}
+
+func makeCheckpointer(g *Graph, c CrawlConfig) *checkpointer {
+	every := c.CheckpointEvery
+	if every <= 0 {
+		every = DefaultCheckpointEvery
+	}
+	now := time.Now()
+	return &checkpointer{g: g, file: c.Checkpoint, every: every, last: now, start: now}
+}
+
+// maybe writes a checkpoint if the last one is old enough
+func (cp *checkpointer) maybe() {
+	if cp.file == "" {
+		return
+	}
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	if time.Since(cp.last) < cp.every {
+		return
+	}
+	cp.write(false)
+}
+
+// must hold cp.lock
+func (cp *checkpointer) write(done bool) {
+	now := time.Now()
+	cp.g.lock.Lock()
+	cp.g.elapsed += now.Sub(cp.start)
+	cp.g.lock.Unlock()
+	cp.start, cp.last = now, now
+	if cp.file == "" {
+		return
+	}
+	s := MakeSnapshot(cp.g)
+	s.Checkpoint = &CheckpointState{
+		Elapsed: cp.g.Elapsed(),
+		Done:    done,
+	}
+	err := saveSnapshot(s, cp.file)
+	if err != nil {
+		logger.Warn("could not write checkpoint", "file", cp.file, log.Err(err))
+		return
+	}
+	logger.Debug("wrote checkpoint", "file", cp.file, "nodes", len(s.Nodes))
+}
+
+func (cp *checkpointer) finish(done bool) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	cp.write(done)
+}
+
+// Elapsed is the time crawls of g have taken, including those before it
+// was saved
+func (g *Graph) Elapsed() time.Duration {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.elapsed
+}
+
+func (g *Graph) failed(id, stage string) bool {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	for _, f := range g.failures {
+		if f.ID == id && f.Stage == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Resume continues the crawl checkpointed in filename with the limits it
+// was started with, checkpointing to the same file. Papers already expanded
+// are not fetched again, and neither are those that failed. Papers cut
+// short by the node budget carry on after the last bibliography entry
+// they looked up.
+func Resume(ctx context.Context, filename string, cb func(Node)) (*Graph, error) {
+	g, err := Load(filename)
+	if err != nil {
+		return nil, err
+	}
+	c := g.Config()
+	c.Checkpoint = filename
+	return g, Crawl(ctx, g, c, cb)
+}
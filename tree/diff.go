@@ -0,0 +1,297 @@
+package tree
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/benjaminchristie/go-arxiv-tree/api"
+)
+
+// Diff is what changed from one graph to another, such as the same topic
+// crawled twice. Papers are matched by canonical ID.
+type Diff struct {
+	OldRoot      string        `json:"old_root"`
+	NewRoot      string        `json:"new_root"`
+	Added        []DiffNode    `json:"added"`
+	Removed      []DiffNode    `json:"removed"`
+	AddedEdges   []Edge        `json:"added_edges"`
+	RemovedEdges []Edge        `json:"removed_edges"`
+	Changed      []DiffNode    `json:"changed"` // in both, with different metadata
+	Moved        []DepthChange `json:"moved"`   // in both, at different depths
+}
+
+type DiffNode struct {
+	ID      string   `json:"id"`
+	Title   string   `json:"title"`
+	Depth   int      `json:"depth"`
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// Change is one metadata field of a paper, before and after
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type DepthChange struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Old   int    `json:"old"`
+	New   int    `json:"new"`
+}
+
+// Empty reports whether the two graphs hold the same papers, citations
+// and metadata
+func (d Diff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.AddedEdges)+len(d.RemovedEdges)+len(d.Changed)+len(d.Moved) == 0 &&
+		d.OldRoot == d.NewRoot
+}
+
+// metadata are the fields Compare looks at, by name
+var metadata = []struct {
+	name  string
+	value func(g *Graph, n Node) string
+}{
+	{"title", func(_ *Graph, n Node) string { return n.Info.Title }},
+	{"version", func(_ *Graph, n Node) string {
+		if n.Info.Version == 0 {
+			return ""
+		}
+		return fmt.Sprint(n.Info.Version)
+	}},
+	{"updated", func(_ *Graph, n Node) string { return n.Info.Updated }},
+	{"published", func(_ *Graph, n Node) string { return n.Info.Published }},
+	{"authors", func(_ *Graph, n Node) string { return strings.Join(n.Info.Authors, "; ") }},
+	{"category", func(_ *Graph, n Node) string { return n.Info.Category }},
+	{"categories", func(_ *Graph, n Node) string { return strings.Join(n.Info.Categories, "; ") }},
+	{"abstract", func(_ *Graph, n Node) string { return n.Info.Abstract }},
+	{"status", func(g *Graph, n Node) string { return g.Status(n) }},
+}
+
+// Compare lists what changed going from one graph to the other. Metadata
+// missing on either side, like the version of a paper saved before
+// versions were kept, is not reported as changed.
+func Compare(from, to *Graph) Diff {
+	d := Diff{
+		OldRoot:      from.Root().ID,
+		NewRoot:      to.Root().ID,
+		Added:        []DiffNode{},
+		Removed:      []DiffNode{},
+		AddedEdges:   []Edge{},
+		RemovedEdges: []Edge{},
+		Changed:      []DiffNode{},
+		Moved:        []DepthChange{},
+	}
+	for _, n := range to.Nodes() {
+		o, ok := from.Node(n.ID)
+		if !ok {
+			d.Added = append(d.Added, DiffNode{ID: n.ID, Title: n.Info.Title, Depth: n.Depth})
+			continue
+		}
+		var changes []Change
+		for _, m := range metadata {
+			a, b := m.value(from, o), m.value(to, n)
+			if a != b && a != "" && b != "" {
+				changes = append(changes, Change{Field: m.name, Old: a, New: b})
+			}
+		}
+		if len(changes) > 0 {
+			d.Changed = append(d.Changed, DiffNode{ID: n.ID, Title: n.Info.Title, Depth: n.Depth, Changes: changes})
+		}
+		if o.Depth != n.Depth {
+			d.Moved = append(d.Moved, DepthChange{ID: n.ID, Title: n.Info.Title, Old: o.Depth, New: n.Depth})
+		}
+	}
+	for _, o := range from.Nodes() {
+		if _, ok := to.Node(o.ID); !ok {
+			d.Removed = append(d.Removed, DiffNode{ID: o.ID, Title: o.Info.Title, Depth: o.Depth})
+		}
+	}
+	oldEdges, newEdges := edgeSet(from), edgeSet(to)
+	for _, e := range to.Edges() {
+		if !oldEdges[e] {
+			d.AddedEdges = append(d.AddedEdges, e)
+		}
+	}
+	for _, e := range from.Edges() {
+		if !newEdges[e] {
+			d.RemovedEdges = append(d.RemovedEdges, e)
+		}
+	}
+	return d
+}
+
+// VersionGraph is the paper id and the papers the bibliography of its
+// given version cites, at most maxRefs of them when maxRefs > 0. Comparing
+// the graphs of two versions shows the references a revision added and
+// dropped.
+func VersionGraph(ctx context.Context, id string, version, maxRefs int) (*Graph, error) {
+	id = CanonicalID(id)
+	var info Paper
+	err := MakeInfoFromQueryContext(ctx, &info, api.QueryRequest{IDList: id}, false)
+	if err != nil {
+		return nil, err
+	}
+	if version < 1 || (info.Version != 0 && version > info.Version) {
+		return nil, fmt.Errorf("%s has no version %d, the latest is %d", id, version, info.Version)
+	}
+	g := MakeGraph(info)
+	e := g.nodes[g.root]
+	// the e-print of that version rather than the latest
+	e.Info.ID = fmt.Sprintf("%sv%d", id, version)
+	e.Info.Version = version
+	err = g.expand(ctx, e, 0, CrawlConfig{MaxRefs: maxRefs}, nil, func(*entry, bool) {})
+	e.Info.ID = id
+	return g, err
+}
+
+func edgeSet(g *Graph) map[Edge]bool {
+	s := map[Edge]bool{}
+	for _, e := range g.Edges() {
+		s[e] = true
+	}
+	return s
+}
+
+func (d Diff) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// WriteText writes d as a readable report, a section per kind of change
+func (d Diff) WriteText(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	if d.Empty() {
+		fmt.Fprintln(b, "No changes")
+		return b.Flush()
+	}
+	if d.OldRoot != d.NewRoot {
+		fmt.Fprintf(b, "Root changed: %s -> %s\n", d.OldRoot, d.NewRoot)
+	}
+	nodes := func(name, mark string, ns []DiffNode) {
+		if len(ns) == 0 {
+			return
+		}
+		fmt.Fprintf(b, "%s (%d):\n", name, len(ns))
+		for _, n := range ns {
+			fmt.Fprintf(b, "  %s %-12s depth %d  %.70s\n", mark, n.ID, n.Depth, n.Title)
+		}
+	}
+	edges := func(name, mark string, es []Edge) {
+		if len(es) == 0 {
+			return
+		}
+		fmt.Fprintf(b, "%s (%d):\n", name, len(es))
+		for _, e := range es {
+			fmt.Fprintf(b, "  %s %s -> %s\n", mark, e.From, e.To)
+		}
+	}
+	nodes("Added papers", "+", d.Added)
+	nodes("Removed papers", "-", d.Removed)
+	edges("Added citations", "+", d.AddedEdges)
+	edges("Removed citations", "-", d.RemovedEdges)
+	if len(d.Changed) > 0 {
+		fmt.Fprintf(b, "Changed papers (%d):\n", len(d.Changed))
+		for _, n := range d.Changed {
+			fmt.Fprintf(b, "  ~ %-12s %.70s\n", n.ID, n.Title)
+			for _, c := range n.Changes {
+				fmt.Fprintf(b, "      %s: %.60q -> %.60q\n", c.Field, c.Old, c.New)
+			}
+		}
+	}
+	if len(d.Moved) > 0 {
+		fmt.Fprintf(b, "Depth changes (%d):\n", len(d.Moved))
+		for _, m := range d.Moved {
+			fmt.Fprintf(b, "  %-12s %d -> %d  %.70s\n", m.ID, m.Old, m.New, m.Title)
+		}
+	}
+	return b.Flush()
+}
+
+// colors of the diff DOT
+const (
+	diffAdded   = "#b7e4c7"
+	diffRemoved = "#f5a3a3"
+	diffChanged = "#ffd580"
+	diffMoved   = "#cfe2ff"
+)
+
+// WriteDiffDOT draws from and to as one graph, green for what to adds,
+// red for what it removes, orange for papers whose metadata changed and
+// blue for papers that only moved to another depth
+func WriteDiffDOT(w io.Writer, from, to *Graph) error {
+	d := Compare(from, to)
+	status := map[string]string{}
+	for _, n := range d.Added {
+		status[n.ID] = diffAdded
+	}
+	for _, n := range d.Removed {
+		status[n.ID] = diffRemoved
+	}
+	for _, m := range d.Moved {
+		status[m.ID] = diffMoved
+	}
+	for _, n := range d.Changed {
+		status[n.ID] = diffChanged
+	}
+	nodes := to.Nodes()
+	for _, n := range from.Nodes() {
+		if _, ok := to.Node(n.ID); !ok {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].Depth != nodes[j].Depth {
+			return nodes[i].Depth < nodes[j].Depth
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "digraph diff {")
+	fmt.Fprintln(b, "\trankdir=LR;")
+	fmt.Fprintln(b, `	node [shape=box, style="rounded,filled", fillcolor="#ffffff", fontname="Helvetica", fontsize=10];`)
+	fmt.Fprintln(b, `	edge [color="#888888"];`)
+	for _, n := range nodes {
+		attrs := []string{
+			"label=" + quote(n.Info.Label()),
+			"tooltip=" + quote(n.Info.Title),
+		}
+		if c := status[n.ID]; c != "" {
+			attrs = append(attrs, "fillcolor="+quote(c))
+		}
+		if status[n.ID] == diffRemoved {
+			attrs = append(attrs, `style="rounded,filled,dashed"`)
+		}
+		if n.ID == d.NewRoot || n.ID == d.OldRoot {
+			attrs = append(attrs, "penwidth=2")
+		}
+		fmt.Fprintf(b, "\t%s [%s];\n", quote(n.ID), strings.Join(attrs, ", "))
+	}
+	added, removed := map[Edge]bool{}, map[Edge]bool{}
+	for _, e := range d.AddedEdges {
+		added[e] = true
+	}
+	for _, e := range d.RemovedEdges {
+		removed[e] = true
+	}
+	for _, e := range append(to.Edges(), d.RemovedEdges...) {
+		var attrs string
+		switch {
+		case added[e]:
+			attrs = ` [color="#2d6a4f", penwidth=2]`
+		case removed[e]:
+			attrs = ` [color="#c1121f", style=dashed]`
+		}
+		fmt.Fprintf(b, "\t%s -> %s%s;\n", quote(e.From), quote(e.To), attrs)
+	}
+	fmt.Fprintln(b, "}")
+	return b.Flush()
+}
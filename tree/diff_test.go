@@ -0,0 +1,169 @@
+package tree
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// testSnapshot is a snapshot rooted at the first paper named, from
+// citations written "a>b" for a citing b, with depths from the root
+func testSnapshot(citations ...string) Snapshot {
+	s := Snapshot{Version: SnapshotVersion, Nodes: []SnapshotNode{}}
+	index := map[string]int{}
+	refs := map[string][]string{}
+	add := func(id string) {
+		if _, ok := index[id]; !ok {
+			index[id] = len(s.Nodes)
+			s.Nodes = append(s.Nodes, SnapshotNode{ID: id, Title: "Paper " + id, Depth: -1})
+		}
+	}
+	for _, c := range citations {
+		from, to, _ := strings.Cut(c, ">")
+		add(from)
+		add(to)
+		refs[from] = append(refs[from], to)
+		s.Edges = append(s.Edges, Edge{From: from, To: to})
+	}
+	s.Root = s.Nodes[0].ID
+	s.Nodes[0].Depth = 0
+	for queue := []string{s.Root}; len(queue) > 0; queue = queue[1:] {
+		d := s.Nodes[index[queue[0]]].Depth
+		for _, r := range refs[queue[0]] {
+			if n := &s.Nodes[index[r]]; n.Depth < 0 {
+				n.Depth = d + 1
+				queue = append(queue, r)
+			}
+		}
+	}
+	return s
+}
+
+func (s Snapshot) node(id string) *SnapshotNode {
+	for i := range s.Nodes {
+		if s.Nodes[i].ID == id {
+			return &s.Nodes[i]
+		}
+	}
+	return nil
+}
+
+func testGraph(t *testing.T, s Snapshot) *Graph {
+	t.Helper()
+	g, err := s.Graph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestCompareSame(t *testing.T) {
+	s := testSnapshot("a>b", "a>c", "b>c")
+	d := Compare(testGraph(t, s), testGraph(t, s))
+	if !d.Empty() {
+		t.Errorf("Compare of one graph with itself = %+v", d)
+	}
+	var b bytes.Buffer
+	d.WriteText(&b)
+	if b.String() != "No changes\n" {
+		t.Errorf("WriteText = %q", b.String())
+	}
+}
+
+func TestCompare(t *testing.T) {
+	old := testSnapshot("a>b", "a>c", "b>e")
+	old.node("b").Version = 1
+	now := testSnapshot("a>b", "b>d", "d>e")
+	now.node("b").Version = 2
+	now.node("b").Title = "Paper b, revised"
+	now.node("a").Version = 3 // no version before, not a change
+	d := Compare(testGraph(t, old), testGraph(t, now))
+
+	ids := func(ns []DiffNode) string {
+		var s []string
+		for _, n := range ns {
+			s = append(s, n.ID)
+		}
+		return strings.Join(s, " ")
+	}
+	edges := func(es []Edge) string {
+		var s []string
+		for _, e := range es {
+			s = append(s, e.From+">"+e.To)
+		}
+		return strings.Join(s, " ")
+	}
+	if got := ids(d.Added); got != "d" {
+		t.Errorf("Added = %s, want d", got)
+	}
+	if got := ids(d.Removed); got != "c" {
+		t.Errorf("Removed = %s, want c", got)
+	}
+	if got := edges(d.AddedEdges); got != "b>d d>e" {
+		t.Errorf("AddedEdges = %s, want b>d d>e", got)
+	}
+	if got := edges(d.RemovedEdges); got != "a>c b>e" {
+		t.Errorf("RemovedEdges = %s, want a>c b>e", got)
+	}
+	if len(d.Changed) != 1 || d.Changed[0].ID != "b" {
+		t.Fatalf("Changed = %+v, want only b", d.Changed)
+	}
+	var fields []string
+	for _, c := range d.Changed[0].Changes {
+		fields = append(fields, c.Field+":"+c.Old+">"+c.New)
+	}
+	if got := strings.Join(fields, " "); got != "title:Paper b>Paper b, revised version:1>2" {
+		t.Errorf("changes of b = %s", got)
+	}
+	if len(d.Moved) != 1 || d.Moved[0] != (DepthChange{ID: "e", Title: "Paper e", Old: 2, New: 3}) {
+		t.Errorf("Moved = %+v, want e from depth 2 to 3", d.Moved)
+	}
+
+	var b bytes.Buffer
+	if err := d.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	var back Diff
+	if err := json.Unmarshal(b.Bytes(), &back); err != nil {
+		t.Fatal(err)
+	}
+	if ids(back.Added) != "d" || len(back.Changed[0].Changes) != 2 {
+		t.Errorf("JSON round trip = %+v", back)
+	}
+	b.Reset()
+	d.WriteText(&b)
+	for _, want := range []string{"Added papers (1):", "+ d", "- c", "+ b -> d", "version: \"1\" -> \"2\"", "e            2 -> 3"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("WriteText has no %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestCompareRoot(t *testing.T) {
+	d := Compare(testGraph(t, testSnapshot("a>b")), testGraph(t, testSnapshot("b>a")))
+	if d.Empty() || d.OldRoot != "a" || d.NewRoot != "b" {
+		t.Errorf("Compare with another root = %+v", d)
+	}
+}
+
+func TestWriteDiffDOT(t *testing.T) {
+	old := testGraph(t, testSnapshot("a>b", "a>c"))
+	now := testGraph(t, testSnapshot("a>b", "a>d"))
+	var b bytes.Buffer
+	if err := WriteDiffDOT(&b, old, now); err != nil {
+		t.Fatal(err)
+	}
+	dot := b.String()
+	for _, want := range []string{
+		`"d" [label=`, `fillcolor="` + diffAdded + `"`,
+		`"c" [label=`, `fillcolor="` + diffRemoved + `", style="rounded,filled,dashed"`,
+		`"a" -> "d" [color="#2d6a4f", penwidth=2];`,
+		`"a" -> "c" [color="#c1121f", style=dashed];`,
+		`"a" -> "b";`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("diff DOT has no %q:\n%s", want, dot)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package tree
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ways to color nodes, see DOTOptions
+const (
+	ColorNone     = "none"
+	ColorDepth    = "depth"
+	ColorCategory = "category"
+	ColorStatus   = "status"
+)
+
+// ways to group nodes, see DOTOptions
+const (
+	ClusterNone     = "none"
+	ClusterRoot     = "root" // by the seed closest to each paper
+	ClusterCategory = "category"
+)
+
+// DOTOptions styles the output of WriteGraphDOT
+type DOTOptions struct {
+	Color   string // one of the Color constants, empty for none
+	Cluster string // one of the Cluster constants, empty for none
+	// scale nodes by how many papers in the graph cite them
+	SizeByCitations bool
+	// merge the leaves cited at most this many times into one node per
+	// citing paper, 0 keeps every leaf
+	CollapseLeaves int
+	// drawn in PathColor over everything else, see Graph.ShortestPath
+	Paths []Path
+}
+
+// PathColor outlines the papers and citations on DOTOptions.Paths
+const PathColor = "#d62828"
+
+// Highlight returns the papers and citations on the paths of o
+func (o DOTOptions) Highlight(g *Graph) (map[string]bool, map[Edge]bool) {
+	nodes, edges := map[string]bool{}, map[Edge]bool{}
+	for _, p := range o.Paths {
+		for _, id := range p {
+			nodes[id] = true
+		}
+		for _, e := range g.Steps(p) {
+			edges[e] = true
+		}
+	}
+	return nodes, edges
+}
+
+var DefaultDOTOptions = DOTOptions{
+	Color:           ColorDepth,
+	SizeByCitations: true,
+}
+
+func (o DOTOptions) Validate() error {
+	switch o.Color {
+	case "", ColorNone, ColorDepth, ColorCategory, ColorStatus:
+	default:
+		return fmt.Errorf("unknown color %q, want %s, %s, %s or %s", o.Color, ColorNone, ColorDepth, ColorCategory, ColorStatus)
+	}
+	switch o.Cluster {
+	case "", ClusterNone, ClusterRoot, ClusterCategory:
+	default:
+		return fmt.Errorf("unknown cluster %q, want %s, %s or %s", o.Cluster, ClusterNone, ClusterRoot, ClusterCategory)
+	}
+	if o.CollapseLeaves < 0 {
+		return errors.New("cannot collapse leaves cited fewer than 0 times")
+	}
+	return nil
+}
+
+var depthColors = []string{"#fdd49e", "#fdbb84", "#fc8d59", "#ef6548", "#d7301f", "#b30000", "#7f0000"}
+
+var categoryColors = []string{
+	"#8dd3c7", "#ffffb3", "#bebada", "#fb8072", "#80b1d3", "#fdb462",
+	"#b3de69", "#fccde5", "#d9d9d9", "#bc80bd", "#ccebc5", "#ffed6f",
+}
+
+var statusColors = map[string]string{
+	StatusExpanded:   "#b7e4c7",
+	StatusUnexpanded: "#e9ecef",
+	StatusFailed:     "#f5a3a3",
+	StatusFiltered:   "#c5d8f0",
+}
+
+// Color is the fill color of n when nodes are colored by one of the Color
+// constants, or "" if n has none
+func (g *Graph) Color(n Node, by string) string {
+	switch by {
+	case ColorDepth:
+		return depthColors[min(n.Depth, len(depthColors)-1)]
+	case ColorCategory:
+		if n.Info.Category == "" {
+			return ""
+		}
+		// hashed, so a category keeps its color from graph to graph
+		h := fnv.New32a()
+		h.Write([]byte(n.Info.Category))
+		return categoryColors[h.Sum32()%uint32(len(categoryColors))]
+	case ColorStatus:
+		return statusColors[g.Status(n)]
+	}
+	return ""
+}
+
+// FirstAuthor is the first of p's authors, or "" if none is known
+func (p *Paper) FirstAuthor() string {
+	if len(p.Authors) > 0 {
+		return p.Authors[0]
+	}
+	// bibtex lists authors as "A and B and C"
+	a, _, _ := strings.Cut(p.Author, " and ")
+	return strings.TrimSpace(a)
+}
+
+// Label is a short description of p: its title cut to a few words, then
+// its first author and year
+func (p *Paper) Label() string {
+	var by []string
+	if a := p.FirstAuthor(); a != "" {
+		if len(p.Authors) > 1 || strings.Contains(p.Author, " and ") {
+			a += " et al."
+		}
+		by = append(by, a)
+	}
+	if y := p.Year(); y != 0 {
+		by = append(by, fmt.Sprint(y))
+	}
+	l := shorten(strings.Join(strings.Fields(p.Title), " "), 40)
+	if len(by) > 0 {
+		l += "\n" + strings.Join(by, ", ")
+	}
+	return l
+}
+
+// shorten cuts s to at most n runes, at a word boundary if it can
+func shorten(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	cut := string(r[:n])
+	if i := strings.LastIndexByte(cut, ' '); i > n/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.:;-") + "…"
+}
+
+// quote makes s a DOT string. Newlines become line breaks.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\r", "")
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+func Visualize(n *ArxivTree, filename string) error {
+	if n == nil {
+		return errors.New("nothing to visualize")
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return WriteDOT(file, n)
+}
+
+// VisualizeGraph writes g to filename as DOT styled by o
+func VisualizeGraph(g *Graph, filename string, o DOTOptions) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = WriteGraphDOT(file, g, o)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// WriteDOT writes the tree below n as Graphviz DOT, styled with
+// DefaultDOTOptions
+func WriteDOT(w io.Writer, n *ArxivTree) error {
+	if n == nil {
+		return errors.New("nothing to visualize")
+	}
+	return WriteGraphDOT(w, treeGraph(n), DefaultDOTOptions)
+}
+
+// treeGraph copies the tree below n into a graph. Papers without an ID
+// are keyed by title.
+func treeGraph(n *ArxivTree) *Graph {
+	key := func(c *ArxivTree) string {
+		if id := CanonicalID(c.Paper.ID); id != "" {
+			return id
+		}
+		return "title:" + c.Paper.Title
+	}
+	root := *n.Paper
+	root.ID = key(n)
+	g := MakeGraph(root)
+	Traverse(n, func(c *ArxivTree) {
+		if c != n {
+			info := *c.Paper
+			info.ID = key(c)
+			g.add(info, c.Depth()-n.Depth())
+			g.link(key(c.parent), info.ID)
+		}
+		if len(c.children) != 0 {
+			g.nodes[key(c)].Expanded = true
+		}
+	})
+	return g
+}
+
+// WriteGraphDOT writes g as Graphviz DOT, keyed by arXiv ID
+func WriteGraphDOT(w io.Writer, g *Graph, o DOTOptions) error {
+	err := o.Validate()
+	if err != nil {
+		return err
+	}
+	nodes := g.Nodes()
+	collapsed := collapseLeaves(nodes, o.CollapseLeaves)
+	onPath, pathEdges := o.Highlight(g)
+	for id := range onPath {
+		delete(collapsed, id)
+	}
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "digraph citations {")
+	fmt.Fprintln(b, "\trankdir=LR;")
+	fmt.Fprintln(b, `	node [shape=box, style="rounded,filled", fillcolor="#ffffff", fontname="Helvetica", fontsize=10];`)
+	fmt.Fprintln(b, `	edge [color="#888888"];`)
+
+	// nodes go into clusters, or straight into the graph under ""
+	clusters := map[string][]string{}
+	var order []string
+	put := func(cluster, line string) {
+		if _, ok := clusters[cluster]; !ok {
+			order = append(order, cluster)
+		}
+		clusters[cluster] = append(clusters[cluster], line)
+	}
+	for _, n := range nodes {
+		if _, ok := collapsed[n.ID]; ok {
+			continue
+		}
+		put(clusterOf(g, n, o.Cluster), nodeDOT(g, n, o, onPath[n.ID]))
+	}
+	groups := map[string]int{}
+	for _, parent := range collapsed {
+		groups[parent]++
+	}
+	for _, n := range nodes {
+		if k := groups[n.ID]; k != 0 {
+			put(clusterOf(g, n, o.Cluster), fmt.Sprintf(`%s [label=%s, shape=note, style=dashed];`,
+				quote("leaves:"+n.ID), quote(fmt.Sprintf("%d more papers", k))))
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i] < order[j]
+	})
+	for i, c := range order {
+		indent := "\t"
+		if c != "" {
+			fmt.Fprintf(b, "\tsubgraph %s {\n", quote(fmt.Sprintf("cluster_%d", i)))
+			fmt.Fprintf(b, "\t\tlabel=%s;\n", quote(c))
+			indent = "\t\t"
+		}
+		for _, l := range clusters[c] {
+			fmt.Fprintln(b, indent+l)
+		}
+		if c != "" {
+			fmt.Fprintln(b, "\t}")
+		}
+	}
+
+	seen := map[Edge]bool{}
+	for _, e := range g.Edges() {
+		if parent, ok := collapsed[e.To]; ok {
+			e.To = "leaves:" + parent
+		}
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+		attrs := ""
+		if pathEdges[e] {
+			attrs = fmt.Sprintf(" [color=%s, penwidth=2.5]", quote(PathColor))
+		}
+		fmt.Fprintf(b, "\t%s -> %s%s;\n", quote(e.From), quote(e.To), attrs)
+	}
+	fmt.Fprintln(b, "}")
+	return b.Flush()
+}
+
+// collapseLeaves maps every leaf cited at most max times to the paper
+// whose group it joins. A paper with a single such leaf keeps it, and
+// seeds are never collapsed.
+func collapseLeaves(nodes []Node, max int) map[string]string {
+	collapsed := map[string]string{}
+	if max == 0 {
+		return collapsed
+	}
+	leaves := map[string][]string{}
+	for _, n := range nodes {
+		if n.Depth == 0 || len(n.Refs) != 0 || len(n.CitedBy) == 0 || len(n.CitedBy) > max {
+			continue
+		}
+		leaves[n.CitedBy[0]] = append(leaves[n.CitedBy[0]], n.ID)
+	}
+	for parent, ids := range leaves {
+		if len(ids) < 2 {
+			continue
+		}
+		for _, id := range ids {
+			collapsed[id] = parent
+		}
+	}
+	return collapsed
+}
+
+func clusterOf(g *Graph, n Node, by string) string {
+	switch by {
+	case ClusterRoot:
+		seed, ok := g.Node(n.NearestSeed(g.Seeds()))
+		if !ok {
+			return ""
+		}
+		return seed.Info.Label()
+	case ClusterCategory:
+		return n.Info.Category
+	}
+	return ""
+}
+
+func nodeDOT(g *Graph, n Node, o DOTOptions, onPath bool) string {
+	attrs := []string{
+		"label=" + quote(n.Info.Label()),
+		"tooltip=" + quote(n.Info.Title),
+	}
+	if !strings.HasPrefix(n.ID, "title:") {
+		attrs = append(attrs, "URL="+quote("https://arxiv.org/abs/"+n.ID))
+	}
+	fill := g.Color(n, o.Color)
+	if fill != "" {
+		attrs = append(attrs, "fillcolor="+quote(fill))
+	}
+	if o.SizeByCitations && len(n.CitedBy) > 1 {
+		size := 10 + 4*math.Sqrt(float64(len(n.CitedBy)-1))
+		attrs = append(attrs, fmt.Sprintf("fontsize=%.1f", math.Min(size, 32)))
+	}
+	switch {
+	case onPath:
+		attrs = append(attrs, "color="+quote(PathColor), "penwidth=3")
+	case n.Depth == 0:
+		attrs = append(attrs, "penwidth=2")
+	}
+	return fmt.Sprintf("%s [%s];", quote(n.ID), strings.Join(attrs, ", "))
+}
@@ -0,0 +1,353 @@
+package tree
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benjaminchristie/go-arxiv-tree/events"
+)
+
+// MatchThreshold is the title similarity below which the arXiv paper a
+// reference resolved to is taken to be some other paper, see Filter.Matched
+const MatchThreshold = 0.5
+
+// Filter decides which papers a crawl takes in. Each list lets a paper
+// through if any entry matches, and the nil Filter lets everything through.
+// A paper missing a field, like a reference not looked up on arXiv yet,
+// passes the checks on that field.
+type Filter struct {
+	Expr              string
+	YearMin, YearMax  int      // 0 leaves that end open
+	Categories        []string // "cs.LG", or "cs.*" for the whole archive
+	ExcludeCategories []string
+	Authors           []string // case insensitive substrings of author names
+	ExcludeAuthors    []string
+	Titles            []*regexp.Regexp
+	ExcludeTitles     []*regexp.Regexp
+	Matched           bool // drop references arXiv has no close match for
+}
+
+// ParseFilter reads a filter expression. Terms are separated by spaces and
+// must all hold, a leading - or ! negates one (! for places where a
+// leading - reads as a flag), and repeating a key lists alternatives:
+//
+//	year:2015..2020  year:>=2018  year:..2012
+//	cat:cs.LG  cat:cs.*  -cat:math.*
+//	author:hinton  -author:"van der"
+//	title:transformer  title:/graph (neural )?networks?/  -title:survey
+//	matched
+//
+// Titles given without slashes match case insensitively anywhere. The
+// empty expression gives the nil Filter.
+func ParseFilter(expr string) (*Filter, error) {
+	terms, err := splitTerms(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	f := &Filter{Expr: strings.TrimSpace(expr)}
+	for _, t := range terms {
+		negate := strings.HasPrefix(t, "-") || strings.HasPrefix(t, "!")
+		if negate {
+			t = t[1:]
+		}
+		key, value, ok := strings.Cut(t, ":")
+		if !ok {
+			if strings.EqualFold(t, "matched") && !negate {
+				f.Matched = true
+				continue
+			}
+			return nil, fmt.Errorf("filter term %q is not key:value", t)
+		}
+		value = strings.Trim(value, `"`)
+		if value == "" {
+			return nil, fmt.Errorf("filter term %q has no value", t)
+		}
+		switch strings.ToLower(key) {
+		case "year":
+			if negate {
+				return nil, fmt.Errorf("filter term %q: give year ranges without -", t)
+			}
+			err = f.parseYears(value)
+		case "cat", "category":
+			if negate {
+				f.ExcludeCategories = append(f.ExcludeCategories, value)
+			} else {
+				f.Categories = append(f.Categories, value)
+			}
+		case "author":
+			if negate {
+				f.ExcludeAuthors = append(f.ExcludeAuthors, strings.ToLower(value))
+			} else {
+				f.Authors = append(f.Authors, strings.ToLower(value))
+			}
+		case "title":
+			var re *regexp.Regexp
+			re, err = titleRegexp(value)
+			if negate {
+				f.ExcludeTitles = append(f.ExcludeTitles, re)
+			} else {
+				f.Titles = append(f.Titles, re)
+			}
+		default:
+			err = fmt.Errorf("unknown filter key %q, want year, cat, author or title", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// splitTerms splits expr at spaces outside quotes and /regexps/
+func splitTerms(expr string) ([]string, error) {
+	var terms []string
+	var b strings.Builder
+	var in rune // the quote or slash being read up to, 0 for none
+	escaped := false
+	for _, r := range expr {
+		switch {
+		case escaped:
+			escaped = false
+		case in != 0 && r == '\\':
+			escaped = true
+		case in != 0 && r == in:
+			in = 0
+		case in == 0 && r == '"':
+			in = r
+		case in == 0 && r == '/' && strings.HasSuffix(b.String(), ":"):
+			in = r
+		case in == 0 && (r == ' ' || r == '\t' || r == '\n'):
+			if b.Len() > 0 {
+				terms = append(terms, b.String())
+				b.Reset()
+			}
+			continue
+		}
+		b.WriteRune(r)
+	}
+	if in != 0 {
+		return nil, fmt.Errorf("filter %q has an unclosed %c", expr, in)
+	}
+	if b.Len() > 0 {
+		terms = append(terms, b.String())
+	}
+	return terms, nil
+}
+
+func (f *Filter) parseYears(v string) error {
+	year := func(s string) (int, error) {
+		if s == "" {
+			return 0, nil
+		}
+		y, err := strconv.Atoi(s)
+		if err != nil || y < 1000 || y > 9999 {
+			return 0, fmt.Errorf("bad year %q", s)
+		}
+		return y, nil
+	}
+	// a comparison needs a year to compare with
+	bound := func(s string) (int, error) {
+		if s == "" {
+			return 0, fmt.Errorf("year range %s has no year", v)
+		}
+		return year(s)
+	}
+	var err error
+	switch {
+	case v == "..":
+		err = fmt.Errorf("year range %s has no year", v)
+	case strings.Contains(v, ".."):
+		lo, hi, _ := strings.Cut(v, "..")
+		f.YearMin, err = year(lo)
+		if err == nil {
+			f.YearMax, err = year(hi)
+		}
+	case strings.HasPrefix(v, ">="):
+		f.YearMin, err = bound(v[2:])
+	case strings.HasPrefix(v, "<="):
+		f.YearMax, err = bound(v[2:])
+	case strings.HasPrefix(v, ">"):
+		f.YearMin, err = bound(v[1:])
+		f.YearMin++
+	case strings.HasPrefix(v, "<"):
+		f.YearMax, err = bound(v[1:])
+		f.YearMax--
+	default:
+		f.YearMin, err = year(v)
+		f.YearMax = f.YearMin
+	}
+	if err == nil && f.YearMin != 0 && f.YearMax != 0 && f.YearMin > f.YearMax {
+		err = fmt.Errorf("year range %s is empty", v)
+	}
+	return err
+}
+
+func titleRegexp(v string) (*regexp.Regexp, error) {
+	if len(v) >= 2 && strings.HasPrefix(v, "/") && strings.HasSuffix(v, "/") {
+		return regexp.Compile("(?i)" + v[1:len(v)-1])
+	}
+	return regexp.Compile("(?i)" + regexp.QuoteMeta(v))
+}
+
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.Expr
+}
+
+// Check returns why p does not pass f, or "" if it does
+func (f *Filter) Check(p *Paper) string {
+	if f == nil {
+		return ""
+	}
+	if y := p.Year(); y != 0 {
+		if f.YearMin != 0 && y < f.YearMin {
+			return fmt.Sprintf("published in %d, before %d", y, f.YearMin)
+		}
+		if f.YearMax != 0 && y > f.YearMax {
+			return fmt.Sprintf("published in %d, after %d", y, f.YearMax)
+		}
+	}
+	cats := p.Categories
+	if len(cats) == 0 && p.Category != "" {
+		cats = []string{p.Category}
+	}
+	if len(cats) != 0 {
+		if len(f.Categories) != 0 && !anyCategory(cats, f.Categories) {
+			return fmt.Sprintf("category %s is not one of %s", strings.Join(cats, ", "), strings.Join(f.Categories, ", "))
+		}
+		for _, c := range cats {
+			if anyCategory([]string{c}, f.ExcludeCategories) {
+				return "category " + c + " is excluded"
+			}
+		}
+	}
+	authors := strings.ToLower(strings.Join(append([]string{p.Author}, p.Authors...), "; "))
+	if strings.Trim(authors, "; ") != "" {
+		if len(f.Authors) != 0 && !anySubstring(authors, f.Authors) {
+			return "no author matches " + strings.Join(f.Authors, ", ")
+		}
+		for _, a := range f.ExcludeAuthors {
+			if strings.Contains(authors, a) {
+				return "author " + a + " is excluded"
+			}
+		}
+	}
+	if p.Title != "" {
+		if len(f.Titles) != 0 && !anyRegexp(p.Title, f.Titles) {
+			return "title matches none of the title filters"
+		}
+		for _, re := range f.ExcludeTitles {
+			if re.MatchString(p.Title) {
+				return "title matches excluded " + re.String()[4:]
+			}
+		}
+	}
+	if f.Matched && p.ID != "" && p.Confidence < MatchThreshold {
+		return fmt.Sprintf("no close arXiv match, best was %s at %.2f", p.ID, p.Confidence)
+	}
+	return ""
+}
+
+func anyCategory(cats, patterns []string) bool {
+	for _, c := range cats {
+		for _, p := range patterns {
+			if strings.EqualFold(c, p) ||
+				(strings.HasSuffix(p, "*") && strings.HasPrefix(strings.ToLower(c), strings.ToLower(p[:len(p)-1]))) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func anySubstring(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyRegexp(s string, res []*regexp.Regexp) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// filters parses the filter expressions of c
+func (c CrawlConfig) filters() (resolve, expand *Filter, err error) {
+	resolve, err = ParseFilter(c.Resolve)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolve filter: %w", err)
+	}
+	expand, err = ParseFilter(c.Expand)
+	if err != nil {
+		return nil, nil, fmt.Errorf("expand filter: %w", err)
+	}
+	return resolve, expand, nil
+}
+
+// Filtered is a reference a crawl filter kept out of the graph, at stage
+// "resolve", or a paper it kept from being expanded, at stage "expand"
+type Filtered struct {
+	ID       string    `json:"id,omitempty"` // empty when filtered before lookup
+	Title    string    `json:"title"`
+	ParentID string    `json:"parent,omitempty"` // the citing paper, at stage "resolve"
+	Depth    int       `json:"depth"`
+	Stage    string    `json:"stage"`
+	Reason   string    `json:"reason"`
+	Time     time.Time `json:"time"`
+}
+
+// filter records that p, found at depth, was filtered out at stage, once
+func (g *Graph) filter(parentID string, p Paper, depth int, stage, reason string) {
+	g.lock.Lock()
+	for _, f := range g.filtered {
+		if f.ID == p.ID && f.ParentID == parentID && f.Title == p.Title && f.Stage == stage {
+			g.lock.Unlock()
+			return
+		}
+	}
+	g.filtered = append(g.filtered, Filtered{
+		ID:       p.ID,
+		Title:    p.Title,
+		ParentID: parentID,
+		Depth:    depth,
+		Stage:    stage,
+		Reason:   reason,
+		Time:     time.Now(),
+	})
+	g.lock.Unlock()
+	events.Emit(events.Filtered(p.ID, p.Title, depth, stage, reason))
+}
+
+// Filtered returns every paper the crawl filters left out, oldest first
+func (g *Graph) Filtered() []Filtered {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return append([]Filtered(nil), g.filtered...)
+}
+
+// held reports whether the expand filter kept id from being expanded
+func (g *Graph) held(id string) bool {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	for _, f := range g.filtered {
+		if f.ID == id && f.Stage == "expand" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package tree
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		expr string
+		want Filter // without Expr and the title regexps
+		// the sources of the title regexps
+		wantTitles, wantExcludeTitles []string
+	}{
+		{expr: "year:2015..2020", want: Filter{YearMin: 2015, YearMax: 2020}},
+		{expr: "year:>=2018", want: Filter{YearMin: 2018}},
+		{expr: "year:>2018", want: Filter{YearMin: 2019}},
+		{expr: "year:<=2012", want: Filter{YearMax: 2012}},
+		{expr: "year:<2012", want: Filter{YearMax: 2011}},
+		{expr: "year:..2012", want: Filter{YearMax: 2012}},
+		{expr: "year:2017", want: Filter{YearMin: 2017, YearMax: 2017}},
+		{expr: "cat:cs.LG cat:stat.*", want: Filter{Categories: []string{"cs.LG", "stat.*"}}},
+		{expr: "category:cs.CL -cat:math.*", want: Filter{Categories: []string{"cs.CL"}, ExcludeCategories: []string{"math.*"}}},
+		{expr: "!cat:math.*", want: Filter{ExcludeCategories: []string{"math.*"}}},
+		{expr: `author:Hinton -author:"Van Der"`, want: Filter{Authors: []string{"hinton"}, ExcludeAuthors: []string{"van der"}}},
+		{expr: "AUTHOR:lecun", want: Filter{Authors: []string{"lecun"}}},
+		{expr: "title:transformer -title:survey", wantTitles: []string{`(?i)transformer`}, wantExcludeTitles: []string{`(?i)survey`}},
+		{expr: "title:/graph (neural )?networks?/", wantTitles: []string{`(?i)graph (neural )?networks?`}},
+		{expr: "title:a+b", wantTitles: []string{`(?i)a\+b`}},
+		{expr: "matched", want: Filter{Matched: true}},
+		{expr: "  matched   year:2020..  ", want: Filter{Matched: true, YearMin: 2020}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := ParseFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseFilter(%q): %v", tt.expr, err)
+			}
+			got, want := *f, tt.want
+			if got.Expr == "" {
+				t.Errorf("Expr is empty")
+			}
+			if sources(got.Titles) != joined(tt.wantTitles) {
+				t.Errorf("Titles = %s, want %v", sources(got.Titles), tt.wantTitles)
+			}
+			if sources(got.ExcludeTitles) != joined(tt.wantExcludeTitles) {
+				t.Errorf("ExcludeTitles = %s, want %v", sources(got.ExcludeTitles), tt.wantExcludeTitles)
+			}
+			got.Expr, got.Titles, got.ExcludeTitles = "", nil, nil
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ParseFilter(%q) = %+v, want %+v", tt.expr, got, want)
+			}
+		})
+	}
+}
+
+func TestParseFilterEmpty(t *testing.T) {
+	for _, expr := range []string{"", "   ", "\t\n"} {
+		f, err := ParseFilter(expr)
+		if f != nil || err != nil {
+			t.Errorf("ParseFilter(%q) = %v, %v, want nil, nil", expr, f, err)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		"transformer",         // not key:value
+		"-matched",            // matched cannot be negated
+		"year:",               // no value
+		`author:""`,           // no value once unquoted
+		"year:20",             // not a year
+		"year:2020..2015",     // empty range
+		"year:abc..",          // bad lower end
+		"year:<",              // no upper bound
+		"year:>",              // no lower bound
+		"year:<=",             // no upper bound
+		"year:>=",             // no lower bound
+		"year:..",             // neither end
+		"-year:2015..2020",    // year ranges are not negated
+		"venue:neurips",       // unknown key
+		`title:"unclosed`,     // unclosed quote
+		"title:/unclosed",     // unclosed regexp
+		"title:/(unbalanced/", // bad regexp
+	} {
+		f, err := ParseFilter(expr)
+		if err == nil {
+			t.Errorf("ParseFilter(%q) = %+v, want an error", expr, f)
+		}
+	}
+}
+
+func TestFilterCheck(t *testing.T) {
+	f, err := ParseFilter("year:2015.. cat:cs.* -author:smith -title:survey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		p    Paper
+		pass bool
+	}{
+		{Paper{Title: "Attention", Published: "2017-06-12", Category: "cs.CL", Authors: []string{"Ashish Vaswani"}}, true},
+		{Paper{Title: "Old", Published: "2012-01-01", Category: "cs.LG"}, false},
+		{Paper{Title: "Maths", Published: "2019-01-01", Category: "math.CO"}, false},
+		{Paper{Title: "A Survey of Things", Published: "2019-01-01", Category: "cs.LG"}, false},
+		{Paper{Title: "Graphs", Published: "2019-01-01", Category: "cs.LG", Authors: []string{"John Smith"}}, false},
+		// a reference not looked up yet has only its title
+		{Paper{Title: "Unresolved"}, true},
+	}
+	for _, tt := range tests {
+		reason := f.Check(&tt.p)
+		if (reason == "") != tt.pass {
+			t.Errorf("Check(%q) = %q, want pass %v", tt.p.Title, reason, tt.pass)
+		}
+	}
+	var none *Filter
+	if reason := none.Check(&Paper{Title: "Anything"}); reason != "" {
+		t.Errorf("nil Filter rejected a paper: %s", reason)
+	}
+}
+
+func sources(res []*regexp.Regexp) string {
+	var s []string
+	for _, re := range res {
+		s = append(s, re.String())
+	}
+	return joined(s)
+}
+
+func joined(s []string) string {
+	return strings.Join(s, " | ")
+}
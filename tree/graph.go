@@ -0,0 +1,707 @@
+package tree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/benjaminchristie/go-arxiv-tree/arxiv_logger"
+	"github.com/benjaminchristie/go-arxiv-tree/events"
+)
+
+// Node is a paper in a Graph. Graph methods hand out copies, so a Node is
+// safe to keep while the crawl goes on.
+type Node struct {
+	ID       string // canonical arXiv ID
+	Info     Paper
+	Depth    int            // shortest distance from any seed
+	Seeds    map[string]int // the seeds that reach this paper, by ID, at their distance
+	Refs     []string       // IDs this paper cites, in bibliography order
+	CitedBy  []string       // IDs in the graph citing this paper
+	Expanded bool           // Refs have been resolved
+	Looked   int            // bibliography entries already looked up when Refs were cut short
+}
+
+// NearestSeed is the seed closest to n, the first of seeds on a tie, or ""
+// if none reaches it
+func (n Node) NearestSeed(seeds []string) string {
+	nearest := ""
+	for _, s := range seeds {
+		d, ok := n.Seeds[s]
+		if ok && (nearest == "" || d < n.Seeds[nearest]) {
+			nearest = s
+		}
+	}
+	return nearest
+}
+
+// Edge points from a citing paper to the paper it cites
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type entry struct {
+	Node
+	expanding sync.Mutex // held while the references are fetched
+}
+
+// Failure is a paper that could not be resolved, expanded or downloaded
+type Failure struct {
+	ID       string    `json:"id,omitempty"` // empty when resolving failed
+	Title    string    `json:"title"`
+	ParentID string    `json:"parent,omitempty"` // the citing paper, when resolving failed
+	Depth    int       `json:"depth"`
+	Stage    string    `json:"stage"` // "resolve", "references" or "pdf"
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+}
+
+// Graph is a directed citation graph keyed by canonical arXiv ID. Every
+// paper is expanded at most once however many papers cite it. A crawl
+// starts from one or more seeds, the first of which is the root.
+type Graph struct {
+	lock     sync.RWMutex
+	root     string
+	seeds    []string
+	nodes    map[string]*entry
+	changes  int // bumped by every new seed and edge, see reach
+	failures []Failure
+	filtered []Filtered
+	config   CrawlConfig   // of the last crawl
+	elapsed  time.Duration // spent crawling, see Elapsed
+
+	reachLock sync.Mutex
+	reached   map[string]map[string]int // paper, seed, distance
+	reachedAt int                       // changes when reached was computed
+}
+
+var versionRegexp = regexp.MustCompile(`v[0-9]+$`)
+
+// idVersion is the version an arXiv ID ends in, like 2 for 2001.00001v2,
+// or 0 if it names none
+func idVersion(id string) int {
+	v, _ := strconv.Atoi(strings.TrimPrefix(versionRegexp.FindString(id), "v"))
+	return v
+}
+
+// SplitVersion splits an arXiv ID naming a version, like 1706.03762v2,
+// into the canonical ID and the version. ok is false for anything else.
+func SplitVersion(s string) (id string, version int, ok bool) {
+	id, version = CanonicalID(s), idVersion(strings.TrimSpace(s))
+	return id, version, version > 0 && arxivIDRegexp.MatchString(id)
+}
+
+// CanonicalID strips URL and "arXiv:" prefixes and the version suffix, so
+// that every revision of a paper shares a node
+func CanonicalID(id string) string {
+	id = strings.TrimSpace(id)
+	for _, p := range []string{"https://", "http://", "arxiv.org/abs/", "arxiv.org/pdf/", "export.arxiv.org/abs/"} {
+		id = strings.TrimPrefix(id, p)
+	}
+	if len(id) > 6 && strings.EqualFold(id[:6], "arxiv:") {
+		id = id[6:]
+	}
+	id = strings.TrimSuffix(id, ".pdf")
+	return versionRegexp.ReplaceAllString(id, "")
+}
+
+// MakeGraph starts a graph at root and any more seeds, which must all
+// have an ID. Seeds naming the same paper are kept once.
+func MakeGraph(root Paper, more ...Paper) *Graph {
+	g := &Graph{
+		nodes: make(map[string]*entry),
+	}
+	root.ID = CanonicalID(root.ID)
+	g.root = root.ID
+	g.AddSeed(root)
+	for _, p := range more {
+		g.AddSeed(p)
+	}
+	return g
+}
+
+// AddSeed adds p to the graph at depth 0 and crawls start from it too. It
+// reports whether p was not a seed yet.
+func (g *Graph) AddSeed(p Paper) bool {
+	p.ID = CanonicalID(p.ID)
+	g.add(p, 0)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, s := range g.seeds {
+		if s == p.ID {
+			return false
+		}
+	}
+	g.seeds = append(g.seeds, p.ID)
+	g.changes++
+	return true
+}
+
+// Seeds returns the IDs of the seeds, the root first
+func (g *Graph) Seeds() []string {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return append([]string(nil), g.seeds...)
+}
+
+// IsSeed reports whether id is one of the seeds
+func (g *Graph) IsSeed(id string) bool {
+	id = CanonicalID(id)
+	for _, s := range g.Seeds() {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// reach returns how far each seed is from each paper it reaches, following
+// references. It is kept until the next new seed or edge. The caller holds
+// g.lock.
+func (g *Graph) reach() map[string]map[string]int {
+	g.reachLock.Lock()
+	defer g.reachLock.Unlock()
+	if g.reached != nil && g.reachedAt == g.changes {
+		return g.reached
+	}
+	reached := map[string]map[string]int{}
+	for _, s := range g.seeds {
+		dist := map[string]int{s: 0}
+		queue := []string{s}
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			for _, r := range g.nodes[id].Refs {
+				if _, ok := dist[r]; !ok {
+					dist[r] = dist[id] + 1
+					queue = append(queue, r)
+				}
+			}
+		}
+		for id, d := range dist {
+			if reached[id] == nil {
+				reached[id] = map[string]int{}
+			}
+			reached[id][s] = d
+		}
+	}
+	g.reached, g.reachedAt = reached, g.changes
+	return reached
+}
+
+// copyReached is e.copy with the seeds reaching it. The caller holds g.lock.
+func (g *Graph) copyReached(e *entry) Node {
+	n := e.copy()
+	n.Seeds = make(map[string]int, len(g.reach()[e.ID]))
+	for s, d := range g.reach()[e.ID] {
+		n.Seeds[s] = d
+	}
+	return n
+}
+
+// add inserts info at depth, or lowers the depth of an existing node. It
+// reports whether the node is new.
+func (g *Graph) add(info Paper, depth int) (*entry, bool) {
+	e, isNew, _ := g.addWithin(info, depth, 0)
+	return e, isNew
+}
+
+// addWithin is add, refusing new nodes once the graph holds maxNodes
+func (g *Graph) addWithin(info Paper, depth, maxNodes int) (*entry, bool, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if e, ok := g.nodes[info.ID]; ok {
+		e.Depth = min(e.Depth, depth)
+		return e, false, true
+	}
+	if maxNodes > 0 && len(g.nodes) >= maxNodes {
+		return nil, false, false
+	}
+	e := &entry{
+		Node: Node{
+			ID:    info.ID,
+			Info:  info,
+			Depth: depth,
+		},
+	}
+	g.nodes[info.ID] = e
+	return e, true, true
+}
+
+func (g *Graph) link(from, to string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	f, t := g.nodes[from], g.nodes[to]
+	if f == nil || t == nil || from == to {
+		return
+	}
+	for _, r := range f.Refs {
+		if r == to {
+			return
+		}
+	}
+	f.Refs = append(f.Refs, to)
+	t.CitedBy = append(t.CitedBy, from)
+	g.changes++
+}
+
+func (e *entry) copy() Node {
+	n := e.Node
+	n.Refs = append([]string(nil), e.Refs...)
+	n.CitedBy = append([]string(nil), e.CitedBy...)
+	return n
+}
+
+func (g *Graph) Root() Node {
+	n, _ := g.Node(g.root)
+	return n
+}
+
+func (g *Graph) Node(id string) (Node, bool) {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	e, ok := g.nodes[CanonicalID(id)]
+	if !ok {
+		return Node{}, false
+	}
+	return g.copyReached(e), true
+}
+
+func (g *Graph) Len() int {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return len(g.nodes)
+}
+
+// Nodes returns every node ordered by depth, then ID
+func (g *Graph) Nodes() []Node {
+	g.lock.RLock()
+	nodes := make([]Node, 0, len(g.nodes))
+	for _, e := range g.nodes {
+		nodes = append(nodes, g.copyReached(e))
+	}
+	g.lock.RUnlock()
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Depth != nodes[j].Depth {
+			return nodes[i].Depth < nodes[j].Depth
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes
+}
+
+// Edges returns every citation, in the order of Nodes
+func (g *Graph) Edges() []Edge {
+	var edges []Edge
+	for _, n := range g.Nodes() {
+		for _, r := range n.Refs {
+			edges = append(edges, Edge{From: n.ID, To: r})
+		}
+	}
+	return edges
+}
+
+// Tree projects the graph onto a breadth first spanning tree, so each
+// paper appears once, under a parent at its shortest depth. With several
+// seeds it is the tree of the root, see Trees.
+func (g *Graph) Tree() *ArxivTree {
+	return g.Trees()[0]
+}
+
+// Trees is Tree with a tree per seed, in the order of Seeds. The seeds are
+// searched from together, so a paper several seeds reach appears once,
+// under the seed closest to it.
+func (g *Graph) Trees() []*ArxivTree {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	heads := make([]*ArxivTree, 0, len(g.seeds))
+	seen := map[string]bool{}
+	for _, s := range g.seeds {
+		seen[s] = true
+	}
+	var queue []*ArxivTree
+	for _, s := range g.seeds {
+		info := g.nodes[s].Info
+		head := MakeNode(&info)
+		heads = append(heads, head)
+		queue = append(queue, head)
+	}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		for _, r := range g.nodes[t.Paper.ID].Refs {
+			if seen[r] {
+				continue
+			}
+			seen[r] = true
+			info := g.nodes[r].Info
+			queue = append(queue, t.AddChild(&info))
+		}
+	}
+	return heads
+}
+
+// how far a paper got, see Status
+const (
+	StatusExpanded   = "expanded"   // its references are in the graph
+	StatusUnexpanded = "unexpanded" // the crawl stopped before expanding it
+	StatusFailed     = "failed"     // its references could not be fetched
+	StatusFiltered   = "filtered"   // the expand filter kept it unexpanded
+)
+
+// Status is one of the Status constants
+func (g *Graph) Status(n Node) string {
+	switch {
+	case n.Expanded:
+		return StatusExpanded
+	case g.failed(n.ID, "references"):
+		return StatusFailed
+	case g.held(n.ID):
+		return StatusFiltered
+	}
+	return StatusUnexpanded
+}
+
+// Frontier returns the nodes whose references have not been resolved,
+// because the crawl stopped before reaching them or their expansion failed
+func (g *Graph) Frontier() []Node {
+	var frontier []Node
+	for _, n := range g.Nodes() {
+		if !n.Expanded {
+			frontier = append(frontier, n)
+		}
+	}
+	return frontier
+}
+
+// CrawlConfig bounds a crawl. Zero values leave a limit off.
+type CrawlConfig struct {
+	Depth    int           `json:"depth"`     // levels of citations below the root
+	MaxNodes int           `json:"max_nodes"` // papers in the graph, the root included
+	MaxRefs  int           `json:"max_refs"`  // references followed per paper, in bibliography order
+	Deadline time.Duration `json:"deadline"`  // wall clock time for the whole crawl
+	// filter expressions, see ParseFilter. References failing Resolve
+	// stay out of the graph, papers failing Expand stay unexpanded.
+	Resolve string `json:"resolve,omitempty"`
+	Expand  string `json:"expand,omitempty"`
+	// when set, the crawl state is saved here every CheckpointEvery and
+	// when the crawl stops, for Resume
+	Checkpoint      string        `json:"-"`
+	CheckpointEvery time.Duration `json:"-"`
+}
+
+// EstimateRequests is a rough guess at the requests a crawl bounded by c
+// will make from seeds papers, assuming a typical bibliography size and no
+// overlap between them. Each paper takes a query for its ID, a source
+// download and a PDF download.
+func EstimateRequests(c CrawlConfig, seeds int) int {
+	refsPerPaper := 30
+	if c.MaxRefs > 0 {
+		refsPerPaper = min(refsPerPaper, c.MaxRefs)
+	}
+	papers, level := seeds, seeds
+	for i := 0; i < c.Depth && papers < 1e6; i++ {
+		level *= refsPerPaper
+		papers += level
+	}
+	if c.MaxNodes > 0 {
+		papers = min(papers, c.MaxNodes)
+	}
+	return 3 * papers
+}
+
+// Config returns the limits of the last crawl of g
+func (g *Graph) Config() CrawlConfig {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.config
+}
+
+// Fail records that p, found at depth, failed at stage, and emits the
+// matching event. parentID is the citing paper when p could not be resolved.
+func (g *Graph) Fail(parentID string, p Paper, depth int, stage string, err error) {
+	f := Failure{
+		ID:       p.ID,
+		Title:    p.Title,
+		ParentID: parentID,
+		Depth:    depth,
+		Stage:    stage,
+		Error:    err.Error(),
+		Time:     time.Now(),
+	}
+	g.lock.Lock()
+	g.failures = append(g.failures, f)
+	g.lock.Unlock()
+	events.Emit(events.Failed(p.ID, p.Title, depth, stage, err))
+}
+
+// Failures returns every failure recorded so far, oldest first
+func (g *Graph) Failures() []Failure {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return append([]Failure(nil), g.failures...)
+}
+
+// forget drops the failures of id at stage, once a retry has worked
+func (g *Graph) forget(id, stage string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	kept := g.failures[:0]
+	for _, f := range g.failures {
+		if f.ID != id || f.Stage != stage {
+			kept = append(kept, f)
+		}
+	}
+	g.failures = kept
+}
+
+var ErrNodeBudget = errors.New("node budget reached")
+
+func (g *Graph) full(maxNodes int) bool {
+	return maxNodes > 0 && g.Len() >= maxNodes
+}
+
+// expand resolves the references of e, found at level, unless that has
+// already been done. A failed expansion can be retried. Each reference is
+// passed to found as it resolves, with isNew set if it was not in g yet.
+func (g *Graph) expand(ctx context.Context, e *entry, level int, c CrawlConfig, resolve *Filter, found func(n *entry, isNew bool)) error {
+	e.expanding.Lock()
+	defer e.expanding.Unlock()
+	g.lock.RLock()
+	done, from := e.Expanded, e.Looked
+	g.lock.RUnlock()
+	if done {
+		return nil
+	}
+	// a paper whose references were cut short by the node budget stays on
+	// the frontier, and picks up where it stopped when expanded again
+	truncated := false
+	more := func() bool {
+		truncated = truncated || g.full(c.MaxNodes)
+		return !truncated
+	}
+	// checked before a reference is looked up, with what its bibliography
+	// entry says, and again after
+	skip := func(p Paper) bool {
+		reason := resolve.Check(&p)
+		if reason != "" {
+			g.filter(e.ID, p, level+1, "resolve", reason)
+		}
+		return reason != ""
+	}
+	failed := func(p Paper, err error) {
+		g.Fail(e.ID, p, level+1, "resolve", err)
+	}
+	infos, at, looked, err := getInfos(ctx, e.Info, level+1, c.MaxRefs, from, more, skip, failed)
+	// a cancelled expansion keeps what it resolved, like one cut short by
+	// the budget, so resuming does not look it up again
+	cancelled := err != nil && ctx.Err() != nil
+	if err != nil && !cancelled {
+		logger.Warn("could not expand node", log.ID(e.ID), log.Depth(level), log.Err(err))
+		g.Fail("", e.Info, level, "references", err)
+		return err
+	}
+	if !cancelled {
+		g.forget(e.ID, "references")
+	}
+	truncated = truncated || cancelled
+	for i, info := range infos {
+		if info.ID == "" { // unresolved, already reported by getInfos
+			continue
+		}
+		info.ID = CanonicalID(info.ID)
+		if skip(info) {
+			continue
+		}
+		n, isNew, ok := g.addWithin(info, level+1, c.MaxNodes)
+		if !ok {
+			truncated = true
+			looked = at[i]
+			break
+		}
+		if isNew {
+			events.Emit(events.Node(info.ID, e.ID, info.Title, level+1))
+		}
+		g.link(e.ID, info.ID)
+		found(n, isNew)
+	}
+	g.lock.Lock()
+	e.Expanded = !truncated
+	e.Looked = 0
+	if truncated {
+		e.Looked = looked
+	}
+	g.lock.Unlock()
+	if cancelled {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Crawl expands the graph breadth first from its root, one level at a
+// time, until c.Depth levels are done or a limit is hit. Papers g already
+// has expanded are not fetched again. cb runs once per
+// paper as it is added, however many times it is cited. The error says why
+// the crawl stopped early: ErrNodeBudget, context.DeadlineExceeded for
+// c.Deadline, or the error of ctx. Papers left unexpanded are in Frontier.
+func Crawl(ctx context.Context, g *Graph, c CrawlConfig, cb func(Node)) (err error) {
+	if c.Deadline > 0 {
+		// time spent before a checkpoint counts too
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Deadline-g.Elapsed())
+		defer cancel()
+	}
+	resolve, expand, err := c.filters()
+	if err != nil {
+		return err
+	}
+	g.lock.Lock()
+	g.config = c
+	g.lock.Unlock()
+	cp := makeCheckpointer(g, c)
+	defer func() {
+		cp.finish(err == nil)
+	}()
+	var callbacks sync.WaitGroup
+	defer callbacks.Wait()
+	visit := func(e *entry) {
+		if cb == nil {
+			return
+		}
+		n, _ := g.Node(e.ID)
+		callbacks.Add(1)
+		go func() {
+			cb(n)
+			callbacks.Done()
+		}()
+	}
+
+	g.lock.RLock()
+	var level []*entry
+	for _, s := range g.seeds {
+		level = append(level, g.nodes[s])
+	}
+	g.lock.RUnlock()
+	seen, seeds := map[string]bool{}, map[string]bool{}
+	for _, e := range level {
+		seeds[e.ID] = true
+		events.Emit(events.Node(e.ID, "", e.Info.Title, 0))
+		visit(e)
+		seen[e.ID] = true
+	}
+
+	for depth := 0; depth < c.Depth && len(level) > 0; depth++ {
+		var wg sync.WaitGroup
+		for _, e := range level {
+			if ctx.Err() != nil || g.full(c.MaxNodes) {
+				break
+			}
+			if g.failed(e.ID, "references") { // already tried, maybe before a resume
+				continue
+			}
+			if reason := expand.Check(&e.Info); reason != "" && !seeds[e.ID] {
+				g.filter("", e.Info, depth, "expand", reason)
+				continue
+			}
+			if acquireWorker(ctx) != nil {
+				break
+			}
+			wg.Add(1)
+			go func(e *entry) {
+				defer wg.Done()
+				defer releaseWorker()
+				g.expand(ctx, e, depth, c, resolve, func(n *entry, isNew bool) {
+					if isNew {
+						visit(n)
+					}
+				})
+				cp.maybe()
+			}(e)
+		}
+		wg.Wait()
+		// the level was expanded concurrently, but the next one keeps
+		// bibliography order so crawls are repeatable
+		var next []*entry
+		g.lock.RLock()
+		for _, e := range level {
+			for _, r := range e.Refs {
+				if !seen[r] {
+					seen[r] = true
+					next = append(next, g.nodes[r])
+				}
+			}
+		}
+		g.lock.RUnlock()
+		level = next
+		if err := stopReason(ctx, g, c); err != nil {
+			logger.Info("crawl stopped", log.Depth(depth), "nodes", g.Len(), "frontier", len(g.Frontier()), log.Err(err))
+			return err
+		}
+	}
+	return nil
+}
+
+// Expand resolves the references of the paper id on its own, for exploring
+// a graph without crawling it. cb sees each reference as it resolves, or
+// every known reference at once if id was already expanded. Expanding
+// the same paper from several goroutines fetches it once.
+func (g *Graph) Expand(ctx context.Context, id string, cb func(Node)) ([]Node, error) {
+	g.lock.RLock()
+	e, ok := g.nodes[CanonicalID(id)]
+	level := 0
+	if ok {
+		level = e.Depth
+	}
+	g.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the graph", id)
+	}
+	resolved := false
+	err := g.expand(ctx, e, level, CrawlConfig{}, nil, func(n *entry, _ bool) {
+		resolved = true
+		if cb != nil {
+			c, _ := g.Node(n.ID)
+			cb(c)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	n, _ := g.Node(e.ID)
+	refs := make([]Node, 0, len(n.Refs))
+	for _, r := range n.Refs {
+		c, _ := g.Node(r)
+		refs = append(refs, c)
+		if !resolved && cb != nil {
+			cb(c)
+		}
+	}
+	return refs, nil
+}
+
+func stopReason(ctx context.Context, g *Graph, c CrawlConfig) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if g.full(c.MaxNodes) {
+		return ErrNodeBudget
+	}
+	return nil
+}
+
+// PopulateGraph crawls depth levels of citations below the root of g
+func PopulateGraph(g *Graph, depth int, cb func(Node)) {
+	PopulateGraphContext(context.Background(), g, depth, cb)
+}
+
+// PopulateGraphContext is Crawl limited only by depth
+func PopulateGraphContext(ctx context.Context, g *Graph, depth int, cb func(Node)) {
+	Crawl(ctx, g, CrawlConfig{Depth: depth}, cb)
+}
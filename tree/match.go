@@ -0,0 +1,56 @@
+package tree
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/benjaminchristie/go-arxiv-tree/api"
+)
+
+// describe copies the arXiv metadata of e into info, leaving Title and
+// Author to the caller
+func describe(info *Paper, e api.Entry) {
+	info.ID = e.ID[strings.LastIndex(e.ID, "/")+1:]
+	info.Authors = info.Authors[:0]
+	for _, a := range e.Author {
+		info.Authors = append(info.Authors, a.Name)
+	}
+	info.Abstract = strings.Join(strings.Fields(e.Summary), " ")
+	info.Published = e.Published
+	info.Updated = e.Updated
+	info.Version = idVersion(info.ID)
+	info.Categories = info.Categories[:0]
+	for _, c := range e.Categories {
+		info.Categories = append(info.Categories, c.V)
+	}
+	info.Category = e.Category.V
+	if info.Category == "" && len(info.Categories) > 0 {
+		info.Category = info.Categories[0]
+	}
+}
+
+func words(s string) map[string]bool {
+	w := make(map[string]bool)
+	for _, f := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		w[f] = true
+	}
+	return w
+}
+
+// titleSimilarity is the Dice coefficient of the words in a and b, 1 for
+// the same title and 0 for titles with no word in common
+func titleSimilarity(a, b string) float64 {
+	wa, wb := words(a), words(b)
+	if len(wa)+len(wb) == 0 {
+		return 0
+	}
+	common := 0
+	for w := range wa {
+		if wb[w] {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(wa)+len(wb))
+}
@@ -0,0 +1,280 @@
+package tree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxPathLength caps AllPaths when PathOptions.MaxLength is 0
+const DefaultMaxPathLength = 5
+
+// ErrNoPath is returned when two papers are not connected within the
+// limits asked for
+var ErrNoPath = errors.New("no path between the papers")
+
+// PathOptions limits the paths ShortestPath and AllPaths look for
+type PathOptions struct {
+	MaxLength  int  // most citations on a path, 0 for no limit
+	Undirected bool // also step from a paper to the papers citing it
+	Limit      int  // most paths AllPaths returns, 0 for all of them
+}
+
+// Path is the IDs of the papers from one paper to another, both included.
+// Each step follows a citation, against its direction for undirected
+// paths.
+type Path []string
+
+// Len is the number of citations on p
+func (p Path) Len() int {
+	return max(len(p)-1, 0)
+}
+
+func (p Path) String() string {
+	return strings.Join(p, " > ")
+}
+
+// neighbors are the papers one step from id, the papers it cites first,
+// each once even if they also cite id. The caller holds g.lock.
+func (g *Graph) neighbors(id string, undirected bool) []string {
+	e := g.nodes[id]
+	if !undirected {
+		return e.Refs
+	}
+	n := append([]string(nil), e.Refs...)
+	for _, c := range e.CitedBy {
+		if !slices.Contains(e.Refs, c) {
+			n = append(n, c)
+		}
+	}
+	return n
+}
+
+// endpoints canonicalises from and to and checks that g has both
+func (g *Graph) endpoints(from, to string) (string, string, error) {
+	from, to = CanonicalID(from), CanonicalID(to)
+	for _, id := range []string{from, to} {
+		if _, ok := g.Node(id); !ok {
+			return "", "", fmt.Errorf("%s is not in the graph", id)
+		}
+	}
+	return from, to, nil
+}
+
+// ShortestPath returns a path with the fewest citations from the paper
+// from to the paper to, preferring earlier references on a tie, or
+// ErrNoPath
+func (g *Graph) ShortestPath(from, to string, o PathOptions) (Path, error) {
+	from, to, err := g.endpoints(from, to)
+	if err != nil {
+		return nil, err
+	}
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	prev := map[string]string{from: ""}
+	dist := map[string]int{from: 0}
+	queue := []string{from}
+	for len(queue) > 0 && prev[to] == "" && from != to {
+		id := queue[0]
+		queue = queue[1:]
+		if o.MaxLength > 0 && dist[id] >= o.MaxLength {
+			continue
+		}
+		for _, n := range g.neighbors(id, o.Undirected) {
+			if _, ok := prev[n]; !ok {
+				prev[n] = id
+				dist[n] = dist[id] + 1
+				queue = append(queue, n)
+			}
+		}
+	}
+	if _, ok := prev[to]; !ok {
+		return nil, ErrNoPath
+	}
+	var p Path
+	for id := to; id != ""; id = prev[id] {
+		p = append(Path{id}, p...)
+	}
+	return p, nil
+}
+
+// AllPaths returns the paths from the paper from to the paper to that
+// visit no paper twice and take at most o.MaxLength citations, or
+// DefaultMaxPathLength if that is 0. Paths are looked for one length at a
+// time, so the shortest come first and the search stops as soon as
+// o.Limit are found. It returns ErrNoPath if there are none.
+func (g *Graph) AllPaths(from, to string, o PathOptions) ([]Path, error) {
+	from, to, err := g.endpoints(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if o.MaxLength <= 0 {
+		o.MaxLength = DefaultMaxPathLength
+	}
+	g.lock.RLock()
+	dist := g.distancesTo(to, o.MaxLength, o.Undirected)
+	g.lock.RUnlock()
+	var paths []Path
+	on := map[string]bool{}
+	var length int
+	// walk extends p by every step that can still reach to in length
+	// citations, and reports whether the limit has been hit
+	var walk func(p Path) bool
+	walk = func(p Path) bool {
+		id := p[len(p)-1]
+		if id == to {
+			if p.Len() == length {
+				paths = append(paths, append(Path(nil), p...))
+			}
+			return o.Limit > 0 && len(paths) >= o.Limit
+		}
+		on[id] = true
+		defer delete(on, id)
+		for _, n := range g.neighbors(id, o.Undirected) {
+			d, ok := dist[n]
+			if ok && !on[n] && p.Len()+1+d <= length && walk(append(p, n)) {
+				return true
+			}
+		}
+		return false
+	}
+	start, ok := dist[from]
+	for length = start; ok && length <= o.MaxLength; length++ {
+		// the lock is let go between lengths so a crawl is not held up
+		g.lock.RLock()
+		full := walk(Path{from})
+		g.lock.RUnlock()
+		if full {
+			break
+		}
+	}
+	if len(paths) == 0 {
+		return nil, ErrNoPath
+	}
+	return paths, nil
+}
+
+// distancesTo is the fewest steps from each paper to id, for the papers
+// at most max steps away. The caller holds g.lock.
+func (g *Graph) distancesTo(id string, max int, undirected bool) map[string]int {
+	dist := map[string]int{id: 0}
+	queue := []string{id}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if dist[n] == max {
+			continue
+		}
+		// the papers one step before n
+		prev := g.nodes[n].CitedBy
+		if undirected {
+			prev = g.neighbors(n, true)
+		}
+		for _, p := range prev {
+			if _, ok := dist[p]; !ok {
+				dist[p] = dist[n] + 1
+				queue = append(queue, p)
+			}
+		}
+	}
+	return dist
+}
+
+// Steps returns the citation behind each step of p, pointing from the
+// citing paper whichever way p walks it
+func (g *Graph) Steps(p Path) []Edge {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	var steps []Edge
+	for i := 1; i < len(p); i++ {
+		e := Edge{From: p[i-1], To: p[i]}
+		if n := g.nodes[p[i]]; n != nil {
+			for _, r := range n.Refs {
+				if r == p[i-1] {
+					e = Edge{From: p[i], To: p[i-1]}
+				}
+			}
+		}
+		steps = append(steps, e)
+	}
+	return steps
+}
+
+// Meet looks for a path between the papers from and to, both in g, by
+// crawling outward from both ends a level at a time, the end with the
+// smaller frontier first, until the two crawls reach a common paper.
+// Bibliographies only lead to older papers, so the ends usually meet at a
+// paper both cite, and the path returned is the shortest undirected one.
+// c.Depth bounds the levels crawled from each end, and c.MaxNodes,
+// c.MaxRefs and c.Resolve apply as in Crawl. It returns ErrNoPath once
+// neither end can go further.
+func Meet(ctx context.Context, g *Graph, from, to string, c CrawlConfig) (Path, error) {
+	from, to, err := g.endpoints(from, to)
+	if err != nil {
+		return nil, err
+	}
+	resolve, _, err := c.filters()
+	if err != nil {
+		return nil, err
+	}
+	type end struct {
+		frontier []string
+		seen     map[string]bool
+		depth    int
+	}
+	ends := [2]*end{
+		{frontier: []string{from}, seen: map[string]bool{from: true}},
+		{frontier: []string{to}, seen: map[string]bool{to: true}},
+	}
+	for {
+		if p, err := g.ShortestPath(from, to, PathOptions{Undirected: true}); err == nil {
+			return p, nil
+		}
+		var next *end
+		for _, e := range ends {
+			if len(e.frontier) > 0 && e.depth < c.Depth && (next == nil || len(e.frontier) < len(next.frontier)) {
+				next = e
+			}
+		}
+		if next == nil {
+			return nil, ErrNoPath
+		}
+		var wg sync.WaitGroup
+		for _, id := range next.frontier {
+			if ctx.Err() != nil || g.full(c.MaxNodes) || acquireWorker(ctx) != nil {
+				break
+			}
+			g.lock.RLock()
+			e := g.nodes[id]
+			g.lock.RUnlock()
+			wg.Add(1)
+			go func(depth int) {
+				defer wg.Done()
+				defer releaseWorker()
+				g.expand(ctx, e, depth, c, resolve, func(*entry, bool) {})
+			}(next.depth)
+		}
+		wg.Wait()
+		if err := stopReason(ctx, g, c); err != nil {
+			if p, perr := g.ShortestPath(from, to, PathOptions{Undirected: true}); perr == nil {
+				return p, nil
+			}
+			return nil, err
+		}
+		var frontier []string
+		for _, id := range next.frontier {
+			n, _ := g.Node(id)
+			for _, r := range n.Refs {
+				if !next.seen[r] {
+					next.seen[r] = true
+					frontier = append(frontier, r)
+				}
+			}
+		}
+		next.frontier = frontier
+		next.depth++
+	}
+}
@@ -0,0 +1,114 @@
+package tree
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// pathGraph has a cycle, a>b>c>a, and several ways from a to h
+func pathGraph(t *testing.T) *Graph {
+	return testGraph(t, testSnapshot(
+		"a>b", "a>c", "a>d",
+		"b>c", "b>e",
+		"c>e", "c>f", "c>a",
+		"d>g",
+		"e>h",
+		"g>b",
+	))
+}
+
+func paths(ps []Path) string {
+	var s []string
+	for _, p := range ps {
+		s = append(s, strings.Join(p, ""))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestShortestPath(t *testing.T) {
+	g := pathGraph(t)
+	tests := []struct {
+		from, to string
+		o        PathOptions
+		want     string // "" for ErrNoPath
+	}{
+		{"a", "h", PathOptions{}, "abeh"},
+		{"a", "a", PathOptions{}, "a"},
+		{"g", "a", PathOptions{}, "gbca"},
+		{"a", "h", PathOptions{MaxLength: 2}, ""},
+		{"h", "a", PathOptions{}, ""},
+		{"h", "a", PathOptions{Undirected: true}, "heba"},
+		{"f", "d", PathOptions{Undirected: true}, "fcad"},
+	}
+	for _, tt := range tests {
+		p, err := g.ShortestPath(tt.from, tt.to, tt.o)
+		switch {
+		case tt.want == "" && !errors.Is(err, ErrNoPath):
+			t.Errorf("ShortestPath(%s, %s, %+v) = %v, %v, want ErrNoPath", tt.from, tt.to, tt.o, p, err)
+		case tt.want != "" && strings.Join(p, "") != tt.want:
+			t.Errorf("ShortestPath(%s, %s, %+v) = %v, %v, want %s", tt.from, tt.to, tt.o, p, err, tt.want)
+		}
+	}
+	if _, err := g.ShortestPath("a", "zz", PathOptions{}); err == nil || errors.Is(err, ErrNoPath) {
+		t.Errorf("ShortestPath to a paper not in the graph = %v", err)
+	}
+}
+
+func TestAllPaths(t *testing.T) {
+	g := pathGraph(t)
+	tests := []struct {
+		o    PathOptions
+		want string
+	}{
+		{PathOptions{}, "abeh aceh abceh adgbeh"},
+		{PathOptions{MaxLength: 4}, "abeh aceh abceh"},
+		{PathOptions{MaxLength: 3}, "abeh aceh"},
+		{PathOptions{Limit: 1}, "abeh"},
+		{PathOptions{Limit: 3}, "abeh aceh abceh"},
+		// a cites c and c cites a, but c is one step from a all the same
+		{PathOptions{MaxLength: 3, Undirected: true}, "abeh aceh"},
+	}
+	for _, tt := range tests {
+		ps, err := g.AllPaths("a", "h", tt.o)
+		if err != nil || paths(ps) != tt.want {
+			t.Errorf("AllPaths(a, h, %+v) = %s, %v, want %s", tt.o, paths(ps), err, tt.want)
+		}
+	}
+	ps, _ := g.AllPaths("a", "h", PathOptions{})
+	for i := 1; i < len(ps); i++ {
+		if ps[i].Len() < ps[i-1].Len() {
+			t.Errorf("path %d is shorter than the one before it: %s", i, paths(ps))
+		}
+	}
+	if ps, err := g.AllPaths("h", "a", PathOptions{}); !errors.Is(err, ErrNoPath) {
+		t.Errorf("AllPaths against the citations = %s, %v, want ErrNoPath", paths(ps), err)
+	}
+	if ps, err := g.AllPaths("e", "e", PathOptions{}); err != nil || paths(ps) != "e" {
+		t.Errorf("AllPaths from a paper to itself = %s, %v", paths(ps), err)
+	}
+}
+
+func TestSteps(t *testing.T) {
+	g := pathGraph(t)
+	steps := g.Steps(Path{"h", "e", "b", "a"})
+	want := []Edge{{From: "e", To: "h"}, {From: "b", To: "e"}, {From: "a", To: "b"}}
+	if len(steps) != len(want) {
+		t.Fatalf("Steps = %v, want %v", steps, want)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Errorf("step %d = %v, want %v", i, steps[i], want[i])
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	p := Path{"1706.03762", "1409.0473"}
+	if p.Len() != 1 || p.String() != "1706.03762 > 1409.0473" {
+		t.Errorf("Len, String = %d, %q", p.Len(), p.String())
+	}
+	if (Path{}).Len() != 0 {
+		t.Error("the empty path has citations")
+	}
+}
@@ -0,0 +1,73 @@
+package tree
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/benjaminchristie/go-arxiv-tree/bus"
+)
+
+type PoolStatus struct {
+	Capacity int
+	Busy     int // workers expanding a node
+	Waiting  int // nodes waiting for a worker
+}
+
+const poolPublishInterval = 100 * time.Millisecond
+
+var poolBusy atomic.Int64
+var poolWaiting atomic.Int64
+var poolChanged chan bool
+
+// PoolUpdates carries worker pool changes, coalesced to at most one per
+// poolPublishInterval
+var PoolUpdates = bus.MakeTopic[PoolStatus]()
+
+func init() {
+	poolChanged = make(chan bool, 1)
+	go publishPool()
+}
+
+func Pool() PoolStatus {
+	return PoolStatus{
+		Capacity: cap(workerPool),
+		Busy:     int(poolBusy.Load()),
+		Waiting:  int(poolWaiting.Load()),
+	}
+}
+
+func acquireWorker(ctx context.Context) error {
+	poolWaiting.Add(1)
+	notifyPool()
+	defer notifyPool()
+	select {
+	case workerPool <- true:
+		poolWaiting.Add(-1)
+		poolBusy.Add(1)
+		return nil
+	case <-ctx.Done():
+		poolWaiting.Add(-1)
+		return ctx.Err()
+	}
+}
+
+func releaseWorker() {
+	<-workerPool
+	poolBusy.Add(-1)
+	notifyPool()
+}
+
+func notifyPool() {
+	select {
+	case poolChanged <- true:
+	default:
+	}
+}
+
+func publishPool() {
+	for range poolChanged {
+		PoolUpdates.Publish(Pool())
+		time.Sleep(poolPublishInterval)
+	}
+}
@@ -0,0 +1,192 @@
+package tree
+
+import (
+	"errors"
+	"strings"
+)
+
+// Query matches papers by their metadata. Every term must hold, and a
+// paper missing a field a term asks about does not match.
+type Query struct {
+	Expr             string
+	Titles           []string // lower case substrings of the title
+	Authors          []string // lower case substrings of an author name
+	IDs              []string // canonical IDs, any of them
+	YearMin, YearMax int      // 0 leaves that end open
+	Categories       []string // "cs.LG" or "cs.*", any of them
+}
+
+// ParseQuery reads a query with the keys of ParseFilter plus id, such as
+// `author:hinton year:2012.. cat:cs.*` or `id:1706.03762`. Words without
+// a known key or with nothing after it, like "BERT:", match the title, so
+// only a known key with a bad value is an error.
+func ParseQuery(expr string) (Query, error) {
+	q := Query{Expr: strings.TrimSpace(expr)}
+	terms, err := splitTerms(expr)
+	if err != nil {
+		return q, err
+	}
+	for _, t := range terms {
+		key, value, _ := strings.Cut(t, ":")
+		key, value = strings.ToLower(key), strings.Trim(value, `"`)
+		if !queryKeys[key] || value == "" {
+			// "arXiv:1706.03762", "http://..." and the like are IDs
+			if id := CanonicalID(t); id != t && arxivIDRegexp.MatchString(id) {
+				q.IDs = append(q.IDs, id)
+			} else {
+				q.Titles = append(q.Titles, strings.ToLower(strings.Trim(t, `"`)))
+			}
+			continue
+		}
+		switch key {
+		case "title":
+			q.Titles = append(q.Titles, strings.ToLower(value))
+		case "author":
+			q.Authors = append(q.Authors, strings.ToLower(value))
+		case "id":
+			// old style IDs like hep-th/9901001 are case sensitive
+			q.IDs = append(q.IDs, CanonicalID(value))
+		case "year":
+			var f Filter
+			err = f.parseYears(value)
+			q.YearMin, q.YearMax = f.YearMin, f.YearMax
+		case "cat", "category":
+			q.Categories = append(q.Categories, strings.ToLower(value))
+		}
+		if err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+var queryKeys = map[string]bool{"title": true, "author": true, "id": true, "year": true, "cat": true, "category": true}
+
+// Match reports whether p satisfies q. The empty query matches everything.
+func (q Query) Match(p *Paper) bool {
+	title := strings.ToLower(p.Title)
+	for _, t := range q.Titles {
+		if !strings.Contains(title, t) {
+			return false
+		}
+	}
+	if len(q.Authors) != 0 {
+		authors := strings.ToLower(strings.Join(append([]string{p.Author}, p.Authors...), "; "))
+		if !anySubstring(authors, q.Authors) {
+			return false
+		}
+	}
+	if len(q.IDs) != 0 {
+		id := CanonicalID(p.ID)
+		found := false
+		for _, i := range q.IDs {
+			found = found || i == id
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.YearMin != 0 || q.YearMax != 0 {
+		y := p.Year()
+		if y == 0 || (q.YearMin != 0 && y < q.YearMin) || (q.YearMax != 0 && y > q.YearMax) {
+			return false
+		}
+	}
+	if len(q.Categories) != 0 {
+		cats := append([]string{p.Category}, p.Categories...)
+		if !anyCategory(cats, q.Categories) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matcher is q.Match for the nodes of a tree, for Find and friends
+func (q Query) Matcher() func(*ArxivTree) bool {
+	return func(t *ArxivTree) bool {
+		return t.Paper != nil && q.Match(t.Paper)
+	}
+}
+
+// SkipChildren can be returned by a Walk function to leave out the nodes
+// below the one it was called for
+var SkipChildren = errors.New("skip children")
+
+// Walk calls fn for t and every node below it, depth first in child order.
+// path runs from t to the node, both included, and is only valid during
+// the call. An error from fn other than SkipChildren stops the walk and is
+// returned.
+func (t *ArxivTree) Walk(fn func(n *ArxivTree, path []*ArxivTree) error) error {
+	err := t.walk(fn, nil)
+	if err == SkipChildren {
+		return nil
+	}
+	return err
+}
+
+func (t *ArxivTree) walk(fn func(n *ArxivTree, path []*ArxivTree) error, path []*ArxivTree) error {
+	path = append(path, t)
+	err := fn(t, path)
+	if err == SkipChildren {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, c := range t.children {
+		err = c.walk(fn, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errFound = errors.New("found")
+
+// Find returns the first node at or below t that match accepts, depth
+// first, or nil if there is none
+func (t *ArxivTree) Find(match func(*ArxivTree) bool) *ArxivTree {
+	var found *ArxivTree
+	t.Walk(func(n *ArxivTree, _ []*ArxivTree) error {
+		if match(n) {
+			found = n
+			return errFound
+		}
+		return nil
+	})
+	return found
+}
+
+// FindAll returns every node at or below t that match accepts, depth first
+func (t *ArxivTree) FindAll(match func(*ArxivTree) bool) []*ArxivTree {
+	var found []*ArxivTree
+	t.Walk(func(n *ArxivTree, _ []*ArxivTree) error {
+		if match(n) {
+			found = append(found, n)
+		}
+		return nil
+	})
+	return found
+}
+
+// Filter returns a copy of the tree below t holding the nodes keep accepts
+// and the nodes on the way to them, or nil if keep accepts none. The copy
+// shares its papers with t.
+func (t *ArxivTree) Filter(keep func(*ArxivTree) bool) *ArxivTree {
+	var kept []*ArxivTree
+	for _, c := range t.children {
+		if k := c.Filter(keep); k != nil {
+			kept = append(kept, k)
+		}
+	}
+	if len(kept) == 0 && !keep(t) {
+		return nil
+	}
+	n := MakeNode(t.Paper)
+	for _, k := range kept {
+		k.parent = n
+	}
+	n.children = kept
+	return n
+}
@@ -0,0 +1,102 @@
+package tree
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/benjaminchristie/go-arxiv-tree/api"
+	log "github.com/benjaminchristie/go-arxiv-tree/arxiv_logger"
+)
+
+// arxivIDRegexp matches new style IDs like 1706.03762 and old style ones
+// like hep-th/9901001, once canonical
+var arxivIDRegexp = regexp.MustCompile(`^([0-9]{4}\.[0-9]{4,5}|[a-z-]+(\.[A-Z]{2})?/[0-9]{7})$`)
+
+// SeedQuery is the arXiv query for one seed, looked up by ID if it is an
+// arXiv ID or URL and by title otherwise
+func SeedQuery(seed string) api.QueryRequest {
+	if id := CanonicalID(seed); arxivIDRegexp.MatchString(id) {
+		return api.QueryRequest{IDList: id}
+	}
+	return api.QueryRequest{Title: strings.TrimSpace(seed)}
+}
+
+// MakeSeededGraph looks up every seed, see SeedQuery, and starts a graph
+// at them, the first found being the root. Blank seeds are skipped, and
+// seeds that cannot be found are logged and recorded as failures of g. It
+// fails only if no seed is found.
+func MakeSeededGraph(ctx context.Context, seeds []string) (*Graph, error) {
+	var papers []Paper
+	var failed []string
+	var errs []error
+	for _, s := range seeds {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		var info Paper
+		err := MakeInfoFromQueryContext(ctx, &info, SeedQuery(s), true)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// one seed that cannot be found does not sink the others
+			logger.Warn("could not find seed", "seed", s, log.Err(err))
+			failed = append(failed, s)
+			errs = append(errs, fmt.Errorf("seed %q: %w", s, err))
+			continue
+		}
+		papers = append(papers, info)
+	}
+	if len(papers) == 0 {
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		return nil, errors.New("no seeds given")
+	}
+	g := MakeGraph(papers[0], papers[1:]...)
+	for i, s := range failed {
+		g.Fail("", Paper{Title: s}, 0, "resolve", errs[i])
+	}
+	return g, nil
+}
+
+// ReadSeeds reads seeds from filename, one per line. Blank lines and
+// lines starting with # are skipped.
+func ReadSeeds(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var seeds []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		seeds = append(seeds, line)
+	}
+	return seeds, scanner.Err()
+}
+
+// Overlap returns the papers more than one seed reaches, those reached by
+// the most seeds first, then by depth and ID
+func (g *Graph) Overlap() []Node {
+	var shared []Node
+	for _, n := range g.Nodes() {
+		if len(n.Seeds) > 1 {
+			shared = append(shared, n)
+		}
+	}
+	sort.SliceStable(shared, func(i, j int) bool {
+		return len(shared[i].Seeds) > len(shared[j].Seeds)
+	})
+	return shared
+}
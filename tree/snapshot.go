@@ -0,0 +1,191 @@
+package tree
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// SnapshotVersion is written into every snapshot. Load reads any version up
+// to this one.
+const SnapshotVersion = 1
+
+type SnapshotNode struct {
+	ID         string   `json:"id"`
+	Title      string   `json:"title"`
+	Author     string   `json:"author,omitempty"`
+	Authors    []string `json:"authors,omitempty"`
+	Abstract   string   `json:"abstract,omitempty"`
+	Published  string   `json:"published,omitempty"`
+	Updated    string   `json:"updated,omitempty"`
+	Version    int      `json:"version,omitempty"`
+	Category   string   `json:"category,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	Confidence float64  `json:"confidence"`
+	Depth      int      `json:"depth"`
+	Expanded   bool     `json:"expanded"`
+	Looked     int      `json:"looked,omitempty"` // see Node.Looked
+}
+
+// Snapshot is the on-disk form of a Graph. Edges keep the bibliography
+// order of each paper.
+type Snapshot struct {
+	Version  int            `json:"version"`
+	Created  time.Time      `json:"created"`
+	Root     string         `json:"root"`
+	Seeds    []string       `json:"seeds,omitempty"` // the root first
+	Crawl    CrawlConfig    `json:"crawl"`
+	Nodes    []SnapshotNode `json:"nodes"`
+	Edges    []Edge         `json:"edges"`
+	Failures []Failure      `json:"failures"`
+	Filtered []Filtered     `json:"filtered,omitempty"`
+	// only in snapshots written while crawling
+	Checkpoint *CheckpointState `json:"checkpoint,omitempty"`
+}
+
+func MakeSnapshot(g *Graph) Snapshot {
+	s := Snapshot{
+		Version:  SnapshotVersion,
+		Created:  time.Now().UTC(),
+		Root:     g.Root().ID,
+		Seeds:    g.Seeds(),
+		Crawl:    g.Config(),
+		Edges:    g.Edges(),
+		Failures: g.Failures(),
+		Filtered: g.Filtered(),
+	}
+	for _, n := range g.Nodes() {
+		s.Nodes = append(s.Nodes, SnapshotNode{
+			ID:         n.ID,
+			Title:      n.Info.Title,
+			Author:     n.Info.Author,
+			Authors:    n.Info.Authors,
+			Abstract:   n.Info.Abstract,
+			Published:  n.Info.Published,
+			Updated:    n.Info.Updated,
+			Version:    n.Info.Version,
+			Category:   n.Info.Category,
+			Categories: n.Info.Categories,
+			Confidence: n.Info.Confidence,
+			Depth:      n.Depth,
+			Expanded:   n.Expanded,
+			Looked:     n.Looked,
+		})
+	}
+	if s.Edges == nil {
+		s.Edges = []Edge{}
+	}
+	if s.Failures == nil {
+		s.Failures = []Failure{}
+	}
+	return s
+}
+
+// Graph rebuilds the graph s was made from. Papers in it have no source
+// or bibliography on disk, so expanding them fetches those again.
+func (s Snapshot) Graph() (*Graph, error) {
+	if s.Version < 1 || s.Version > SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, want 1 to %d", s.Version, SnapshotVersion)
+	}
+	g := &Graph{
+		root:     s.Root,
+		seeds:    s.Seeds,
+		nodes:    make(map[string]*entry, len(s.Nodes)),
+		failures: s.Failures,
+		filtered: s.Filtered,
+		config:   s.Crawl,
+	}
+	if s.Checkpoint != nil {
+		g.elapsed = s.Checkpoint.Elapsed
+	}
+	for _, n := range s.Nodes {
+		g.nodes[n.ID] = &entry{
+			Node: Node{
+				ID: n.ID,
+				Info: Paper{
+					ID:         n.ID,
+					Title:      n.Title,
+					Author:     n.Author,
+					Authors:    n.Authors,
+					Abstract:   n.Abstract,
+					Published:  n.Published,
+					Updated:    n.Updated,
+					Version:    n.Version,
+					Category:   n.Category,
+					Categories: n.Categories,
+					Confidence: n.Confidence,
+				},
+				Depth:    n.Depth,
+				Expanded: n.Expanded,
+				Looked:   n.Looked,
+			},
+		}
+	}
+	if g.nodes[s.Root] == nil {
+		return nil, fmt.Errorf("snapshot root %q is not among its nodes", s.Root)
+	}
+	if len(g.seeds) == 0 { // written before there were several
+		g.seeds = []string{s.Root}
+	}
+	if g.seeds[0] != s.Root {
+		return nil, fmt.Errorf("snapshot root %q is not its first seed", s.Root)
+	}
+	for _, id := range g.seeds {
+		if g.nodes[id] == nil {
+			return nil, fmt.Errorf("snapshot seed %q is not among its nodes", id)
+		}
+	}
+	for _, e := range s.Edges {
+		if g.nodes[e.From] == nil || g.nodes[e.To] == nil {
+			return nil, fmt.Errorf("snapshot edge %s -> %s has no node", e.From, e.To)
+		}
+		g.link(e.From, e.To)
+	}
+	return g, nil
+}
+
+// Save writes a snapshot of g to filename, replacing it atomically
+func Save(g *Graph, filename string) error {
+	return saveSnapshot(MakeSnapshot(g), filename)
+}
+
+func saveSnapshot(s Snapshot, filename string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".part"
+	err = os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// ReadSnapshot reads the snapshot in filename without building a graph
+func ReadSnapshot(filename string) (Snapshot, error) {
+	var s Snapshot
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return s, fmt.Errorf("%s is not a snapshot: %w", filename, err)
+	}
+	return s, nil
+}
+
+// Load reads a graph saved with Save
+func Load(filename string) (*Graph, error) {
+	s, err := ReadSnapshot(filename)
+	if err != nil {
+		return nil, err
+	}
+	g, err := s.Graph()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return g, nil
+}
@@ -0,0 +1,54 @@
+package tree
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// every temporary source file and extraction directory, so they can be
+// removed on shutdown
+var tempLock sync.Mutex
+var tempPaths []string
+
+func tempFile(pattern string) (string, error) {
+	fh, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	fh.Close()
+	trackTemp(fh.Name())
+	return fh.Name(), nil
+}
+
+func tempDir(pattern string) (string, error) {
+	dirname, err := os.MkdirTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	trackTemp(dirname)
+	return dirname, nil
+}
+
+func trackTemp(path string) {
+	tempLock.Lock()
+	tempPaths = append(tempPaths, path)
+	tempLock.Unlock()
+}
+
+// CleanupTemp removes every temporary file and directory made so far.
+// BibPath and SourcePath of existing nodes are no longer valid afterwards.
+func CleanupTemp() error {
+	tempLock.Lock()
+	paths := tempPaths
+	tempPaths = nil
+	tempLock.Unlock()
+	var errs []error
+	for _, p := range paths {
+		err := os.RemoveAll(p)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
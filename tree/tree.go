@@ -1,39 +1,118 @@
 package tree
 
 import (
+	"context"
 	"errors"
 	"io/fs"
-	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/benjaminchristie/go-arxiv-tree/api"
 	log "github.com/benjaminchristie/go-arxiv-tree/arxiv_logger"
-	"github.com/benjaminchristie/go-arxiv-tree/comms"
-	"github.com/dominikbraun/graph"
-	"github.com/dominikbraun/graph/draw"
+	"github.com/benjaminchristie/go-arxiv-tree/events"
+	ratelimiter "github.com/benjaminchristie/go-arxiv-tree/rate_limiter"
 	"github.com/jschaf/bibtex"
 )
 
+// ArxivTree is one paper in a tree of citations. Build trees with
+// MakeNode and AddChild so parent links stay consistent.
 type ArxivTree struct {
-	Head     *ArxivTree
-	Value    any
-	Children []*ArxivTree
+	Paper    *Paper
+	parent   *ArxivTree
+	children []*ArxivTree
 }
 
-type ArxivTreeInfo struct {
+// Paper is what a crawl knows about one arXiv paper
+type Paper struct {
 	Entry      bibtex.Entry
 	Author     string
 	ID         string
 	SourcePath string
 	BibPath    string
 	Title      string
+	// filled in from arXiv
+	Authors    []string
+	Abstract   string
+	Published  string // RFC 3339, of the first version
+	Updated    string // RFC 3339, of the latest version
+	Version    int    // latest version, 0 if not known
+	Category   string // primary category, like cs.LG
+	Categories []string
+	// how well the arXiv match fits the bibliography entry it was
+	// resolved from, between 0 and 1. Papers looked up directly get 1.
+	Confidence float64
+}
+
+// Year is when p was first published, or 0 if that is not known
+func (p *Paper) Year() int {
+	if len(p.Published) < 4 {
+		return 0
+	}
+	y, err := strconv.Atoi(p.Published[:4])
+	if err != nil {
+		return 0
+	}
+	return y
+}
+
+// ArxivTreeInfo is the old name of Paper
+type ArxivTreeInfo = Paper
+
+func MakeNode(p *Paper) *ArxivTree {
+	return &ArxivTree{Paper: p}
+}
+
+// AddChild appends a node for p below t and returns it
+func (t *ArxivTree) AddChild(p *Paper) *ArxivTree {
+	c := &ArxivTree{Paper: p, parent: t}
+	t.children = append(t.children, c)
+	return c
+}
+
+// Parent is nil for the root
+func (t *ArxivTree) Parent() *ArxivTree {
+	return t.parent
+}
+
+func (t *ArxivTree) Children() []*ArxivTree {
+	return t.children
+}
+
+func (t *ArxivTree) IsRoot() bool {
+	return t.parent == nil
+}
+
+func (t *ArxivTree) Root() *ArxivTree {
+	for t.parent != nil {
+		t = t.parent
+	}
+	return t
+}
+
+// Depth is 0 for the root
+func (t *ArxivTree) Depth() int {
+	d := 0
+	for n := t.parent; n != nil; n = n.parent {
+		d++
+	}
+	return d
+}
+
+// Path lists the nodes from the root down to t
+func (t *ArxivTree) Path() []*ArxivTree {
+	path := make([]*ArxivTree, t.Depth()+1)
+	for i, n := len(path)-1, t; n != nil; i, n = i-1, n.parent {
+		path[i] = n
+	}
+	return path
 }
 
 var biber *bibtex.Biber
 var workerPool chan bool
+var logger = log.Logger("tree")
 
 func init() {
 	biber = &bibtex.Biber{}
@@ -43,41 +122,46 @@ func init() {
 
 // note that if ID is passed, the xml does not need to be retrieved
 // this is a TODO
-func MakeInfoFromQuery(info *ArxivTreeInfo, p api.QueryRequest, downloadSource bool, comms ...comms.Comm) error {
+func MakeInfoFromQuery(info *Paper, p api.QueryRequest, downloadSource bool) error {
+	ctx := ratelimiter.WithClass(context.Background(), ratelimiter.Interactive)
+	return MakeInfoFromQueryContext(ctx, info, p, downloadSource)
+}
+
+// MakeInfoFromQueryContext is MakeInfoFromQuery with a context, which also
+// decides the rate limiter class of its requests.
+func MakeInfoFromQueryContext(ctx context.Context, info *Paper, p api.QueryRequest, downloadSource bool) error {
 	var x string
 	var err error
-	x, err = api.Query(p)
+	x, err = api.QueryContext(ctx, p)
 	if err != nil {
 		return err
 	}
-	for _, c := range comms {
-		go c.Send(api.NetData{
-			Message: x,
-			Size:    len(x),
-		})
-	}
 	xmlEntry := api.ParseXML(x)
 	if len(xmlEntry) == 0 {
 		return errors.New("Parsing XML Failed: you are probably temporarily banned")
 	}
-	fid := xmlEntry[0].ID
-	id := fid[strings.LastIndex(fid, "/")+1:]
-	info.ID = id
-	info.Title = xmlEntry[0].Title
-	info.Author = xmlEntry[0].Author[0].Name
-	var fh *os.File
-	fh, err = os.CreateTemp("", id)
+	describe(info, xmlEntry[0])
+	id := info.ID
+	info.Title = strings.Join(strings.Fields(xmlEntry[0].Title), " ")
+	if len(info.Authors) > 0 {
+		info.Author = info.Authors[0]
+	}
+	info.Confidence = 1
+	if !downloadSource {
+		return nil
+	}
+	var filename string
+	filename, err = tempFile(id)
 	if err != nil {
 		return err
 	}
-	filename := fh.Name()
 	info.SourcePath = filename
-	err = api.DownloadSource(id, filename, comms...)
+	err = api.DownloadSourceContext(ctx, id, filename)
 	if err != nil {
 		return err
 	}
 	var dirname string
-	dirname, err = os.MkdirTemp("", id)
+	dirname, err = tempDir(id)
 	if err != nil {
 		return err
 	}
@@ -109,7 +193,11 @@ func MakeInfoFromQuery(info *ArxivTreeInfo, p api.QueryRequest, downloadSource b
 	return nil
 }
 
-func MakeInfo(info *ArxivTreeInfo, downloadSource bool, comms ...comms.Comm) error {
+func MakeInfo(info *Paper, downloadSource bool) error {
+	return MakeInfoContext(context.Background(), info, downloadSource)
+}
+
+func MakeInfoContext(ctx context.Context, info *Paper, downloadSource bool) error {
 	var err error
 	if info.ID == "" && info.Author == "" && info.Title == "" {
 		info.Author, info.Title, err = api.QueryBibtexEntry(info.Entry)
@@ -120,37 +208,32 @@ func MakeInfo(info *ArxivTreeInfo, downloadSource bool, comms ...comms.Comm) err
 			Title: info.Title,
 		}
 		var x string
-		x, err = api.Query(p)
+		x, err = api.QueryContext(ctx, p)
 		if err != nil {
 			return err
 		}
-		for _, c := range comms {
-			go c.Send(x) // c.Send blocks
-		}
 		xml := api.ParseXML(x)
 		if len(xml) == 0 {
 			return errors.New("Parsing XML Failed: you are probably temporarily banned")
 		}
-		fid := xml[0].ID
-		id := fid[strings.LastIndex(fid, "/")+1:]
-		info.ID = id
+		describe(info, xml[0])
+		info.Confidence = titleSimilarity(info.Title, xml[0].Title)
 	}
 	if downloadSource {
-		fh, err := os.CreateTemp("", info.ID)
+		filename, err := tempFile(info.ID)
 		if err != nil {
 			return err
 		}
-		filename := fh.Name()
 		info.SourcePath = filename
-		err = api.DownloadSource(info.ID, filename, comms...)
+		err = api.DownloadSourceContext(ctx, info.ID, filename)
 		if err != nil {
 			return err
 		}
-		dirname, err := os.MkdirTemp("", info.ID)
+		dirname, err := tempDir(info.ID)
 		if err != nil {
 			return err
 		}
-		err = api.ExtractTargz(filename, dirname, comms...)
+		err = api.ExtractTargz(filename, dirname)
 		if err != nil {
 			return err
 		}
@@ -180,7 +263,7 @@ func MakeInfo(info *ArxivTreeInfo, downloadSource bool, comms ...comms.Comm) err
 }
 
 func MakeTree(e bibtex.Entry, downloadSource bool, id, author, title string) (*ArxivTree, error) {
-	info := ArxivTreeInfo{
+	info := Paper{
 		Entry:  e,
 		ID:     id,
 		Author: author,
@@ -190,39 +273,41 @@ func MakeTree(e bibtex.Entry, downloadSource bool, id, author, title string) (*A
 	if err != nil {
 		return nil, err
 	}
-	tree := &ArxivTree{
-		Head:     nil,
-		Value:    info,
-		Children: nil,
-	}
-	return tree, nil
+	return MakeNode(&info), nil
 }
 
-func getInfos(info ArxivTreeInfo, comms ...comms.Comm) ([]ArxivTreeInfo, error) {
+// getInfos resolves the bibliography of info, whose references sit at
+// level in the tree. Only the first maxRefs entries are looked at when
+// maxRefs > 0, starting after the first from, and resolving stops early
+// once more returns false. Entries skip returns true for are left out
+// without being looked up, and those that cannot be resolved are passed to
+// failed. at holds the position of each paper in the bibliography, and
+// looked how many entries were looked at, from included. If ctx is done
+// part way, the papers resolved until then come back with its error.
+func getInfos(ctx context.Context, info Paper, level, maxRefs, from int, more func() bool, skip func(Paper) bool, failed func(Paper, error)) (infos []Paper, at []int, looked int, err error) {
 	var entries []bibtex.Entry
-	var err error
+	start := time.Now()
 	if info.BibPath == "" { // bib probably not downloaded
-		fh, err := os.CreateTemp("", info.ID)
+		filename, err := tempFile(info.ID)
 		if err != nil {
-			log.Printf("error %s", err.Error())
-			return nil, err
+			logger.Warn("could not fetch references", log.ID(info.ID), log.Err(err))
+			return nil, nil, from, err
 		}
-		filename := fh.Name()
 		info.SourcePath = filename
-		err = api.DownloadSource(info.ID, filename, comms...)
+		err = api.DownloadSourceContext(ctx, info.ID, filename)
 		if err != nil {
-			log.Printf("error %s", err.Error())
-			return nil, err
+			logger.Warn("could not fetch references", log.ID(info.ID), log.Err(err))
+			return nil, nil, from, err
 		}
-		dirname, err := os.MkdirTemp("", info.ID)
+		dirname, err := tempDir(info.ID)
 		if err != nil {
-			log.Printf("error %s", err.Error())
-			return nil, err
+			logger.Warn("could not fetch references", log.ID(info.ID), log.Err(err))
+			return nil, nil, from, err
 		}
-		err = api.ExtractTargz(filename, dirname, comms...)
+		err = api.ExtractTargz(filename, dirname)
 		if err != nil {
-			log.Printf("error %s", err.Error())
-			return nil, err
+			logger.Warn("could not fetch references", log.ID(info.ID), log.Err(err))
+			return nil, nil, from, err
 		}
 		found := false
 		citeFilename := ""
@@ -244,92 +329,71 @@ func getInfos(info ArxivTreeInfo, comms ...comms.Comm) ([]ArxivTreeInfo, error)
 	}
 	entries, err = api.ReadBibtexFile(info.BibPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, from, err
 	}
-	infos := make([]ArxivTreeInfo, len(entries))
-	for i, e := range entries {
-		infos[i].Entry = e
-		MakeInfo(&infos[i], false, comms...)
+	events.Emit(events.References(info.ID, len(entries), time.Since(start)))
+	if maxRefs > 0 && len(entries) > maxRefs {
+		entries = entries[:maxRefs]
 	}
-	return infos, nil
-}
-
-func contains(v *[]*ArxivTree, c *ArxivTree) bool {
-	for _, e := range *v {
-		if c == e {
-			return true
+	looked = min(from, len(entries))
+	for _, e := range entries[looked:] {
+		if ctx.Err() != nil {
+			return infos, at, looked, ctx.Err()
+		}
+		if !more() {
+			break
+		}
+		looked++
+		// only what the bibliography says, MakeInfoContext looks up
+		// papers with no title yet
+		probe := Paper{Entry: e, Published: api.BibtexField(e, bibtex.FieldYear)}
+		probe.Author, probe.Title, _ = api.QueryBibtexEntry(e)
+		if skip(probe) {
+			continue
+		}
+		infos = append(infos, Paper{})
+		at = append(at, looked-1)
+		i := len(infos) - 1
+		infos[i].Entry = e
+		resolveStart := time.Now()
+		err = MakeInfoContext(ctx, &infos[i], false)
+		if err != nil && ctx.Err() != nil {
+			// not a failure of the reference, look it up again next time
+			return infos[:i], at[:i], looked - 1, ctx.Err()
+		}
+		if err != nil {
+			failed(infos[i], err)
+		} else {
+			events.Emit(events.Resolved(info.ID, infos[i].ID, infos[i].Title, time.Since(resolveStart)))
 		}
 	}
-	return false
+	return infos, at, looked, nil
 }
 
 func Traverse(n *ArxivTree, cb func(*ArxivTree)) {
 	cb(n)
-	for _, c := range n.Children {
+	for _, c := range n.children {
 		Traverse(c, cb)
 	}
 }
 
-func Visualize(n *ArxivTree, filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	if n == nil {
-		return nil
-	}
-	g := graph.New(graph.StringHash, graph.Directed())
-	cb := func(c *ArxivTree) {
-		if c == nil {
-			return
-		}
-		t := c.Value.(ArxivTreeInfo).Title
-		h_t := c.Head.Value.(ArxivTreeInfo).Title
-		g.AddVertex(t)
-		g.AddEdge(h_t, t)
-	}
-	t := n.Value.(ArxivTreeInfo).Title
-	g.AddVertex(t)
-	Traverse(n, cb)
-	err = draw.DOT(g, file)
-	return err
+func PopulateTree(t *ArxivTree, depth int, cb func(*ArxivTree)) {
+	PopulateTreeContext(context.Background(), t, depth, cb)
 }
 
-func _populateTree(t *ArxivTree, depth int, wg *sync.WaitGroup, prefix string, cb func(*ArxivTree), comms ...comms.Comm) {
-	go func() {
-		wg.Add(1)
-		cb(t)
-		wg.Done()
-	}()
-	if depth <= 0 {
-		log.Printf("Reached search depth at %s", t.Value.(ArxivTreeInfo).Title)
-		return
-	}
-	infos, err := getInfos(t.Value.(ArxivTreeInfo), comms...)
-	if err != nil {
-		log.Printf("Error in getInfos: %s", err.Error())
-		return
-	}
-	t.Children = make([]*ArxivTree, len(infos))
-	for i, info := range infos {
-		t.Children[i] = &ArxivTree{
-			Head:     t,
-			Value:    info,
-			Children: nil,
-		}
-		workerPool <- true
-		wg.Add(1)
-		go func(n *ArxivTree) {
-			_populateTree(n, depth-1, wg, prefix, cb, comms...)
-			wg.Done()
-			<-workerPool
-		}(t.Children[i])
+// PopulateTreeContext crawls a Graph rooted at t and replaces the children
+// of t with its tree projection. cb runs once per paper. It stops expanding
+// nodes once ctx is done, and returns once every worker has finished,
+// leaving a partial tree behind.
+func PopulateTreeContext(ctx context.Context, t *ArxivTree, depth int, cb func(*ArxivTree)) {
+	g := MakeGraph(*t.Paper)
+	PopulateGraphContext(ctx, g, depth, func(n Node) {
+		cb(MakeNode(&n.Info))
+	})
+	p := g.Tree()
+	t.Paper = p.Paper
+	t.children = p.children
+	for _, c := range t.children {
+		c.parent = t
 	}
 }
-
-func PopulateTree(t *ArxivTree, depth int, cb func(*ArxivTree), comms ...comms.Comm) {
-	var wg sync.WaitGroup
-	_populateTree(t, depth, &wg, "", cb, comms...)
-	wg.Wait()
-
-}
@@ -0,0 +1,72 @@
+package components
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/benjaminchristie/go-arxiv-tree/export"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// MakeExportDialog asks for an export format and file name. The file
+// extension follows the chosen format until the user edits the name.
+func MakeExportDialog(filename string, exportCB func(format, filename string), cancelCB func()) tview.Primitive {
+	names := export.Names()
+	format := ""
+	if f, ok := export.ForFile(filename); ok {
+		format = f.Name
+	} else if len(names) > 0 {
+		format = names[0]
+	}
+	selected := 0
+	for i, n := range names {
+		if n == format {
+			selected = i
+		}
+	}
+
+	form := tview.NewForm()
+	name := tview.NewInputField().
+		SetLabel("File: ").
+		SetText(filename).
+		SetChangedFunc(func(s string) {
+			filename = s
+		})
+	form.
+		SetFieldTextColor(tcell.ColorGhostWhite).
+		SetFieldBackgroundColor(tcell.ColorBlack).
+		SetLabelColor(tcell.ColorOrangeRed).
+		SetButtonTextColor(tcell.ColorOrangeRed).
+		SetButtonBackgroundColor(tcell.ColorBlack).
+		AddDropDown("Format: ", names, selected, func(s string, _ int) {
+			if s == format {
+				return
+			}
+			format = s
+			f, ok := export.Lookup(s)
+			if ok && len(f.Extensions) > 0 {
+				filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + f.Extensions[0]
+				name.SetText(filename)
+			}
+		}).
+		AddFormItem(name).
+		AddButton("Export", func() {
+			exportCB(format, filename)
+		}).
+		AddButton("Cancel", cancelCB)
+	form.SetBorder(true).
+		SetTitle("Export").
+		SetTitleAlign(tview.AlignCenter).
+		SetTitleColor(tcell.ColorOrangeRed).
+		SetBorderColor(tcell.ColorGhostWhite)
+
+	// centre the form on screen
+	return tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(form, 9, 0, true).
+			AddItem(nil, 0, 1, false), 60, 0, true).
+		AddItem(nil, 0, 1, false)
+}
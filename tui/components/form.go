@@ -23,8 +23,10 @@ type TUIPrimitive struct {
 func MakeForm(
 	dropdownCB func(string, int),
 	searchCB, outputDirCB, depthCB func(string),
-	limitCB func(bool),
-	startCB, quitCB func(),
+	limitCB, adaptiveCB func(bool),
+	apiRateCB, downloadRateCB, apiBurstCB, downloadBurstCB, apiInFlightCB, downloadInFlightCB func(string),
+	resolveFilterCB, expandFilterCB, snapshotCB func(string),
+	startCB, resumeCB, openCB, saveCB, exportCB, rankCB, quitCB func(),
 ) *TUIPrimitive {
 	form := tview.NewForm().
 		SetFieldTextColor(tcell.ColorGhostWhite).
@@ -36,9 +38,10 @@ func MakeForm(
 			"Welcome to ArXiv tree. Enter your search criteria below.\n"+
 				"ArXiv may temporarily ban your IP if you send more than one\n"+
 				"request every three seconds. Enable \"Avoid Rate Limit\" below\n"+
-				"to circumvent this. You may also use a VPN for heavy loads.",
+				"to stay under this, and \"Adaptive\" to slow down further\n"+
+				"whenever arXiv pushes back.",
 			0, 5, true, false).
-		AddDropDown("Search by: ", []string{"ID", "Author", "Title"}, 2,
+		AddDropDown("Search by: ", []string{"ID", "Author", "Title", "Seeds"}, 2,
 			dropdownCB,
 		).
 		AddTextArea("Search Query: ", "sample query", 0, 2, 0,
@@ -53,9 +56,54 @@ func MakeForm(
 		AddCheckbox("Avoid Rate Limit: ", false,
 			limitCB,
 		).
+		AddCheckbox("Adaptive: ", false,
+			adaptiveCB,
+		).
+		AddInputField("API Req/sec: ", "", 8, nil,
+			apiRateCB,
+		).
+		AddInputField("Download Req/sec: ", "", 8, nil,
+			downloadRateCB,
+		).
+		AddInputField("API Burst: ", "", 8, nil,
+			apiBurstCB,
+		).
+		AddInputField("Download Burst: ", "", 8, nil,
+			downloadBurstCB,
+		).
+		AddInputField("API In-Flight: ", "", 8, nil,
+			apiInFlightCB,
+		).
+		AddInputField("Download In-Flight: ", "", 8, nil,
+			downloadInFlightCB,
+		).
+		AddInputField("Resolve Filter: ", "", 0, nil,
+			resolveFilterCB,
+		).
+		AddInputField("Expand Filter: ", "", 0, nil,
+			expandFilterCB,
+		).
+		AddInputField("Snapshot File: ", "arxiv-tree.json", 0, nil,
+			snapshotCB,
+		).
 		AddButton("Start",
 			startCB,
 		).
+		AddButton("Resume",
+			resumeCB,
+		).
+		AddButton("Open",
+			openCB,
+		).
+		AddButton("Save",
+			saveCB,
+		).
+		AddButton("Export",
+			exportCB,
+		).
+		AddButton("Rank",
+			rankCB,
+		).
 		AddButton("Quit",
 			quitCB,
 		)
@@ -2,18 +2,24 @@ package components
 
 import (
 	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/benjaminchristie/go-arxiv-tree/api"
 	log "github.com/benjaminchristie/go-arxiv-tree/arxiv_logger"
-	"github.com/benjaminchristie/go-arxiv-tree/comms"
+	"github.com/benjaminchristie/go-arxiv-tree/bus"
+	"github.com/benjaminchristie/go-arxiv-tree/events"
 	"github.com/benjaminchristie/go-arxiv-tree/tree"
 	"github.com/gdamore/tcell/v2"
 	"github.com/navidys/tvxwidgets"
 	"github.com/rivo/tview"
 )
 
+var logger = log.Logger("tui")
+
 func AddToGrid(g *tview.Grid, t *TUIPrimitive) {
 	p := t.GridParameters
 	g.AddItem(
@@ -28,7 +34,9 @@ func AddToGrid(g *tview.Grid, t *TUIPrimitive) {
 	)
 }
 
-func MakeLogs(c *comms.Comm) *TUIPrimitive {
+// MakeLogs shows everything logged by any package, as kept by the logger's
+// ring buffer
+func MakeLogs() *TUIPrimitive {
 	logs := tview.NewTable()
 	logs.SetBorder(true).
 		SetTitle("Logs").
@@ -43,18 +51,14 @@ func MakeLogs(c *comms.Comm) *TUIPrimitive {
 	pSpinnerChan := make(chan string)
 	stopChan := make(chan bool)
 	pStopChan := make(chan bool)
+	spinning := false // a spinner reads stopChan, on the row above row
+	backlog, records, _ := log.Recent().Follow(64)
+	for _, r := range backlog {
+		logs.SetCell(row, 1, logCell(r))
+		row++
+	}
 	go func() {
-		for {
-			pS, ok := <-c.PublicChan
-			if !ok {
-				log.Printf("PublicChan closed")
-				continue
-			}
-			s, ok := pS.(string)
-			if !ok {
-				log.Printf("Error casting to string in MakeLogs callback")
-				continue
-			}
+		for r := range records {
 			logLock.Lock()
 
 			pStopChan = stopChan
@@ -62,15 +66,16 @@ func MakeLogs(c *comms.Comm) *TUIPrimitive {
 			pSpinnerChan = spinnerChan
 			spinnerChan = make(chan string)
 
-			if row != 0 {
+			if spinning {
 				pStopChan <- true
 				close(pSpinnerChan)
 				logs.SetCellSimple(row-1, 0, "|")
 			}
 			logs.SetCellSimple(row, 0, "|")
-			logs.SetCellSimple(row, 1, s)
+			logs.SetCell(row, 1, logCell(r))
 			logLock.Unlock()
 			go spinner.Timer(100*time.Millisecond, spinnerChan, stopChan)
+			spinning = true
 			go func(i int) {
 				for {
 					myS, ok := <-spinnerChan
@@ -98,7 +103,18 @@ func MakeLogs(c *comms.Comm) *TUIPrimitive {
 	}
 }
 
-func MakePDFLogs(c *comms.Comm) *TUIPrimitive {
+func logCell(r log.Record) *tview.TableCell {
+	c := tview.NewTableCell(r.String())
+	switch {
+	case r.Level >= slog.LevelError:
+		c.SetTextColor(tcell.ColorRed)
+	case r.Level >= slog.LevelWarn:
+		c.SetTextColor(tcell.ColorYellow)
+	}
+	return c
+}
+
+func MakePDFLogs(stream *bus.Topic[events.Event]) *TUIPrimitive {
 	logs := tview.NewTable()
 	logs.SetBorder(true).
 		SetTitle("PDFs").
@@ -108,20 +124,15 @@ func MakePDFLogs(c *comms.Comm) *TUIPrimitive {
 
 	var pdfLock sync.Mutex
 	row := 0
+	sub := stream.Subscribe(64, bus.DropOldest)
 	go func() {
-		for {
-			pS, ok := <-c.PublicChan
-			if !ok {
-				log.Printf("PublicChan closed")
-				continue
-			}
-			s, ok := pS.(string)
+		for e := range sub.C {
+			saved, ok := e.(events.PDFSaved)
 			if !ok {
-				log.Printf("Error casting to string in MakePDFLogs callback")
 				continue
 			}
 			pdfLock.Lock()
-			logs.SetCellSimple(row, 0, s)
+			logs.SetCellSimple(row, 0, fmt.Sprintf("PDF: %s", filepath.Base(saved.Path)))
 			row++
 			pdfLock.Unlock()
 		}
@@ -173,7 +184,7 @@ func makeSparkline() *TUIPrimitive {
 			row:           3,
 			column:        0,
 			rowSpan:       1,
-			colSpan:       2,
+			colSpan:       1,
 			minGridHeight: 0,
 			minGridWidth:  100,
 			focus:         false,
@@ -181,7 +192,12 @@ func makeSparkline() *TUIPrimitive {
 	}
 }
 
-func MakeNet(c *comms.Comm) (*TUIPrimitive, *TUIPrimitive) {
+// netPageLines is how many recent events the network page keeps
+const netPageLines = 200
+
+// MakeNet shows crawl events as they happen, with the size of every
+// response in the sparkline
+func MakeNet(stream *bus.Topic[events.Event]) (*TUIPrimitive, *TUIPrimitive) {
 	netpage := makeNetPage()
 	sparkline := makeSparkline()
 
@@ -194,43 +210,47 @@ func MakeNet(c *comms.Comm) (*TUIPrimitive, *TUIPrimitive) {
 
 	networkUsage := make([]float64, sparklineNetWidth*5)
 	var lock sync.Mutex
+	var lines []string
+	sub := stream.Subscribe(64, bus.DropOldest)
 	go func() {
-		log.Printf("In make net go")
-		for {
-			pS, ok := <-c.PublicChan
-			if !ok {
-				log.Printf("PublicChan closed")
-				continue
-			}
-			m, ok := pS.(api.NetData)
-			if !ok {
-				log.Printf("Error casting to api.NetData in MakeNet callback")
-				continue
+		for e := range sub.C {
+			size := -1
+			switch e := e.(type) {
+			case events.QueryCompleted:
+				size = e.Size
+			case events.SourceDownloaded:
+				size = e.Size
+			case events.PDFSaved:
+				size = e.Size
 			}
 			lock.Lock()
-			s := m.Message
-			netpage.Primitive.(*tview.TextArea).SetText(s, false)
-			usage := float64(m.Size)
-			fastAppend(networkUsage, usage)
-			sparkline.Primitive.(*tvxwidgets.Sparkline).SetData(networkUsage)
+			lines = append(lines, e.String())
+			if len(lines) > netPageLines {
+				lines = lines[len(lines)-netPageLines:]
+			}
+			netpage.Primitive.(*tview.TextArea).SetText(strings.Join(lines, "\n"), true)
+			if size >= 0 {
+				fastAppend(networkUsage, float64(size))
+				sparkline.Primitive.(*tvxwidgets.Sparkline).SetData(networkUsage)
+			}
 			lock.Unlock()
 		}
 	}()
 	return netpage, sparkline
 }
 
-func MakeTreeDisplayComponent(t *tree.ArxivTree, c *comms.Comm) *TUIPrimitive {
+// MakeTreeDisplayComponent redraws the tree whenever a node is added, unless
+// the user is busy navigating it
+func MakeTreeDisplayComponent(t *tree.ArxivTree, stream *bus.Topic[events.Event]) *TUIPrimitive {
 	p := MakeTreeDisplay(t)
 
+	sub := stream.Subscribe(16, bus.DropOldest)
 	go func() {
-		for {
-			v, ok := <-c.PublicChan
-			log.Printf("in go func: %v", v)
-			if !ok {
-				log.Printf("PublicChan closed")
+		for e := range sub.C {
+			if _, ok := e.(events.NodeAdded); !ok {
 				continue
 			}
-			p.UpdateChan <- v.(bool)
+			p.UpdateChan <- p.HasFocus()
 		}
 	}()
 	return &TUIPrimitive{
@@ -0,0 +1,152 @@
+package components
+
+import (
+	"fmt"
+
+	"github.com/benjaminchristie/go-arxiv-tree/analytics"
+	"github.com/benjaminchristie/go-arxiv-tree/tree"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// rankColumns are the table columns, by the analytics column they sort on
+var rankColumns = []struct {
+	header string
+	column string
+}{
+	{"PageRank", "pagerank"},
+	{"Cited", "cited"},
+	{"Cites", "cites"},
+	{"Year", "year"},
+	{"Depth", "depth"},
+	{"ID", "id"},
+	{"Title", "title"},
+}
+
+// MakeRanking shows the papers in g ranked by analytics.Scores. Keys 1 to
+// 7, or a click on a header, sort by that column; r marks the selected
+// paper read, u shows only unread papers and Esc closes the table. read
+// is updated in place.
+func MakeRanking(g *tree.Graph, read map[string]bool, closeCB func()) tview.Primitive {
+	all := analytics.Scores(g)
+	sortBy := "pagerank"
+	unreadOnly := false
+	var shown []analytics.Score
+
+	table := tview.NewTable().
+		SetFixed(1, 0).
+		SetSelectable(true, false)
+	table.SetBorder(true).
+		SetTitleAlign(tview.AlignCenter).
+		SetTitleColor(tcell.ColorOrangeRed).
+		SetBorderColor(tcell.ColorGhostWhite)
+
+	fill := func() {
+		analytics.SortBy(all, sortBy)
+		shown = shown[:0]
+		for _, s := range all {
+			if unreadOnly && (read[s.ID] || s.Depth == 0) {
+				continue
+			}
+			shown = append(shown, s)
+		}
+		table.Clear()
+		for c, col := range rankColumns {
+			h := col.header
+			if col.column == sortBy {
+				h += " ▼"
+			}
+			table.SetCell(0, c, tview.NewTableCell(fmt.Sprintf("%d %s", c+1, h)).
+				SetTextColor(tcell.ColorOrangeRed).
+				SetSelectable(false))
+		}
+		for r, s := range shown {
+			title := s.Title
+			color := tcell.ColorGhostWhite
+			if read[s.ID] {
+				title += " (read)"
+				color = tcell.ColorGray
+			}
+			cells := []string{
+				fmt.Sprintf("%.4f", s.PageRank),
+				fmt.Sprint(s.InDegree),
+				fmt.Sprint(s.OutDegree),
+				fmt.Sprint(s.Year),
+				fmt.Sprint(s.Depth),
+				s.ID,
+				title,
+			}
+			for c, v := range cells {
+				cell := tview.NewTableCell(v).SetTextColor(color)
+				if c == len(cells)-1 {
+					cell.SetExpansion(1)
+				} else {
+					cell.SetAlign(tview.AlignRight)
+				}
+				table.SetCell(r+1, c, cell)
+			}
+		}
+		filter := "all papers"
+		if unreadOnly {
+			filter = "unread papers"
+		}
+		table.SetTitle(fmt.Sprintf("Ranking %s by %s (1-7 sort, r read, u unread, Esc close)", filter, sortBy))
+	}
+	fill()
+
+	sortOn := func(c int) {
+		if c >= 0 && c < len(rankColumns) {
+			sortBy = rankColumns[c].column
+			fill()
+			table.Select(1, 0)
+		}
+	}
+	table.SetMouseCapture(func(action tview.MouseAction, event *tcell.EventMouse) (tview.MouseAction, *tcell.EventMouse) {
+		if action != tview.MouseLeftClick {
+			return action, event
+		}
+		x, y := event.Position()
+		row, col := table.CellAt(x, y)
+		if row == 0 {
+			sortOn(col)
+			return tview.MouseConsumed, nil
+		}
+		return action, event
+	})
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEscape:
+			closeCB()
+			return nil
+		case tcell.KeyRune:
+		default:
+			return event
+		}
+		switch r := event.Rune(); {
+		case r >= '1' && r <= '9':
+			sortOn(int(r - '1'))
+		case r == 'r':
+			row, _ := table.GetSelection()
+			if row >= 1 && row <= len(shown) {
+				id := shown[row-1].ID
+				read[id] = !read[id]
+				if !read[id] {
+					delete(read, id)
+				}
+				fill()
+				table.Select(min(row, max(len(shown), 1)), 0)
+			}
+		case r == 'u':
+			unreadOnly = !unreadOnly
+			fill()
+			table.Select(1, 0)
+		case r == 'q':
+			closeCB()
+		default:
+			return event
+		}
+		return nil
+	})
+	table.Select(1, 0)
+	return table
+}
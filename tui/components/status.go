@@ -0,0 +1,83 @@
+package components
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/benjaminchristie/go-arxiv-tree/bus"
+	ratelimiter "github.com/benjaminchristie/go-arxiv-tree/rate_limiter"
+	"github.com/benjaminchristie/go-arxiv-tree/tree"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+func formatStatus(s ratelimiter.Status, p tree.PoolStatus) string {
+	var b strings.Builder
+	for _, l := range s.Limiters {
+		rate := "unlimited"
+		if l.Rate > 0 {
+			rate = fmt.Sprintf("%.2f req/s", l.Rate)
+		}
+		fmt.Fprintf(&b, "%s: %s, %d in flight", l.Name, rate, l.InFlight)
+		if l.NextSlot > 0 {
+			fmt.Fprintf(&b, ", next in %.1fs", l.NextSlot.Seconds())
+		}
+		fmt.Fprintf(&b, "\n  queued: %d interactive, %d metadata, %d bulk\n",
+			l.Queued[ratelimiter.Interactive],
+			l.Queued[ratelimiter.Metadata],
+			l.Queued[ratelimiter.Bulk],
+		)
+	}
+	hosts := make([]string, 0, len(s.Requests))
+	for h := range s.Requests {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	for _, h := range hosts {
+		fmt.Fprintf(&b, "requests to %s: %d\n", h, s.Requests[h])
+	}
+	fmt.Fprintf(&b, "workers: %d/%d busy, %d waiting", p.Busy, p.Capacity, p.Waiting)
+	return b.String()
+}
+
+func MakeStatus() *TUIPrimitive {
+	view := tview.NewTextView()
+	view.SetBorder(true).
+		SetTitle("Limiter").
+		SetBorderColor(tcell.ColorOrangeRed).
+		SetTitleColor(tcell.ColorGhostWhite).
+		SetTitleAlign(tview.AlignCenter)
+
+	go func() {
+		limits := ratelimiter.Updates.Subscribe(1, bus.DropOldest)
+		pool := tree.PoolUpdates.Subscribe(1, bus.DropOldest)
+		// countdowns move without any events, so refresh them regularly too
+		ticker := time.NewTicker(time.Second)
+		s := ratelimiter.Snapshot()
+		p := tree.Pool()
+		for {
+			select {
+			case s = <-limits.C:
+			case p = <-pool.C:
+			case <-ticker.C:
+				s = ratelimiter.Snapshot()
+			}
+			view.SetText(formatStatus(s, p))
+		}
+	}()
+
+	return &TUIPrimitive{
+		Primitive: view,
+		GridParameters: GridParam{
+			row:           3,
+			column:        1,
+			rowSpan:       1,
+			colSpan:       1,
+			minGridHeight: 0,
+			minGridWidth:  100,
+			focus:         false,
+		},
+	}
+}
@@ -1,9 +1,11 @@
 package components
 
 import (
-	log "github.com/benjaminchristie/go-arxiv-tree/arxiv_logger"
+	"context"
+	"fmt"
 	"sync"
 
+	log "github.com/benjaminchristie/go-arxiv-tree/arxiv_logger"
 	"github.com/benjaminchristie/go-arxiv-tree/tree"
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
@@ -12,8 +14,22 @@ import (
 type TreeDisplay struct {
 	*tview.TreeView
 	ArxivHead  *tree.ArxivTree
+	Graph      *tree.Graph       // when set, ArxivHead is re-projected from it on render
+	heads      []*tree.ArxivTree // a tree per seed, ArxivHead first
 	mutex      *sync.Mutex
 	UpdateChan chan bool
+	// Spawn runs lazy expansions in the background. The TUI points it at
+	// its shutdown coordinator, so that quitting cancels and waits for them.
+	Spawn   func(func(ctx context.Context))
+	loading map[string]bool
+	// "/" search, see search
+	searching bool
+	query     string
+	matches   []*tview.TreeNode
+	match     int
+	found     string                          // what the matches are, for the title
+	colors    map[*tview.TreeNode]tcell.Color // of highlighted matches
+	pathFrom  *tree.Paper                     // "p" pressed here, see path
 }
 
 func MakeTreeDisplay(head *tree.ArxivTree) *TreeDisplay {
@@ -22,8 +38,13 @@ func MakeTreeDisplay(head *tree.ArxivTree) *TreeDisplay {
 		TreeView:   tview.NewTreeView(),
 		UpdateChan: make(chan bool),
 		mutex:      &m,
+		loading:    make(map[string]bool),
+		colors:     make(map[*tview.TreeNode]tcell.Color),
+		Spawn: func(fn func(ctx context.Context)) {
+			go fn(context.Background())
+		},
 	}
-	t.TreeView.SetBorder(true).SetBorderColor(tcell.ColorOrangeRed).SetTitle("Current Tree")
+	t.TreeView.SetBorder(true).SetBorderColor(tcell.ColorOrangeRed).SetTitle(treeTitle)
 	if head != nil {
 		t.UpdateHead(head)
 	}
@@ -37,7 +58,12 @@ func MakeTreeDisplay(head *tree.ArxivTree) *TreeDisplay {
 			if len(children) == 0 {
 				n, ok := ref.(*tree.ArxivTree)
 				if !ok {
-					log.Printf("Error casting to *tree.ArxivTree")
+					logger.Error("expected *tree.ArxivTree as node reference")
+					return
+				}
+				if len(n.Children()) == 0 {
+					t.expand(node, n)
+					return
 				}
 				addNode(node, n)
 			} else {
@@ -45,6 +71,7 @@ func MakeTreeDisplay(head *tree.ArxivTree) *TreeDisplay {
 			}
 		},
 	)
+	t.SetInputCapture(t.keys)
 	go t.spin()
 	return t
 }
@@ -52,17 +79,28 @@ func MakeTreeDisplay(head *tree.ArxivTree) *TreeDisplay {
 func (t *TreeDisplay) render() {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	if t.Graph != nil {
+		t.heads = t.Graph.Trees()
+		t.ArxivHead = t.heads[0]
+	}
 	if t.ArxivHead == nil {
-		log.Printf("t.ArxivHead is nil")
+		logger.Debug("t.ArxivHead is nil")
 		return
 	}
 	target := t.GetRoot()
 	if target == nil {
-		log.Printf("target is nil")
+		logger.Debug("target is nil")
 		return
 	}
 	target.ClearChildren()
-	addNode(target, t.ArxivHead)
+	if len(t.heads) > 1 {
+		addSeeds(target, t.heads)
+	} else {
+		addNode(target, t.ArxivHead)
+	}
+	// the nodes they pointed at are gone
+	t.matches = nil
+	clear(t.colors)
 
 }
 
@@ -76,20 +114,87 @@ func (t *TreeDisplay) spin() {
 }
 
 func (t *TreeDisplay) UpdateHead(head *tree.ArxivTree) {
-	root := tview.NewTreeNode(head.Value.(tree.ArxivTreeInfo).Title).
+	root := tview.NewTreeNode(head.Paper.Title).
+		SetReference(head).
 		SetColor(tcell.ColorRed)
 	t.SetRoot(root).SetCurrentNode(root)
 	t.ArxivHead = head
+	t.heads = []*tree.ArxivTree{head}
 	addNode(root, head)
 }
 
+// UpdateHeads shows a tree per seed under a common root
+func (t *TreeDisplay) UpdateHeads(heads []*tree.ArxivTree) {
+	if len(heads) == 1 {
+		t.UpdateHead(heads[0])
+		return
+	}
+	root := tview.NewTreeNode(fmt.Sprintf("%d seeds", len(heads))).
+		SetColor(tcell.ColorRed)
+	t.SetRoot(root).SetCurrentNode(root)
+	t.ArxivHead = heads[0]
+	t.heads = heads
+	addSeeds(root, heads)
+}
+
+// SetGraph shows the tree projection of g, following it as the crawl grows
+func (t *TreeDisplay) SetGraph(g *tree.Graph) {
+	t.mutex.Lock()
+	t.Graph = g
+	t.mutex.Unlock()
+	t.UpdateHeads(g.Trees())
+}
+
+// expand fetches the references of n on demand, adding them below target
+// as they resolve
+func (t *TreeDisplay) expand(target *tview.TreeNode, n *tree.ArxivTree) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	g := t.Graph
+	id := n.Paper.ID
+	if g == nil || t.loading[id] {
+		return
+	}
+	if _, ok := g.Node(id); !ok {
+		return
+	}
+	t.loading[id] = true
+	title := target.GetText()
+	color := target.GetColor()
+	target.SetText(title + " (loading...)").SetColor(tcell.ColorYellow)
+	t.Spawn(func(ctx context.Context) {
+		_, err := g.Expand(ctx, id, func(c tree.Node) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			info := c.Info
+			child := tview.NewTreeNode(info.Title).
+				SetReference(n.AddChild(&info)).
+				SetSelectable(true)
+			target.AddChild(child).SetExpanded(true)
+		})
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		delete(t.loading, id)
+		target.SetText(title)
+		switch {
+		case err != nil:
+			logger.Error("could not expand node", "title", title, log.Err(err))
+			target.SetColor(tcell.ColorRed)
+		case len(target.GetChildren()) != 0:
+			target.SetColor(tcell.ColorGreen)
+		default:
+			target.SetColor(color)
+		}
+	})
+}
+
 func addNode(target *tview.TreeNode, node *tree.ArxivTree) {
 	if node == nil {
 		return
 	}
-	for _, child := range node.Children {
-		hasChildren := len(child.Children) != 0
-		node := tview.NewTreeNode(child.Value.(tree.ArxivTreeInfo).Title).
+	for _, child := range node.Children() {
+		hasChildren := len(child.Children()) != 0
+		node := tview.NewTreeNode(child.Paper.Title).
 			SetReference(child).
 			SetSelectable(true)
 		target.AddChild(node)
@@ -99,19 +204,220 @@ func addNode(target *tview.TreeNode, node *tree.ArxivTree) {
 	}
 }
 
-func findNode(t *tree.ArxivTree, isTrue func(*tree.ArxivTree) bool) *tree.ArxivTree {
-	if isTrue(t) {
-		return t
+// addSeeds adds a node per seed below target, each with its references
+func addSeeds(target *tview.TreeNode, heads []*tree.ArxivTree) {
+	for _, h := range heads {
+		node := tview.NewTreeNode(h.Paper.Title).
+			SetReference(h).
+			SetColor(tcell.ColorRed).
+			SetSelectable(true)
+		target.AddChild(node)
+		addNode(node, h)
+	}
+}
+
+const treeTitle = "Current Tree"
+
+// keys handles "/" to search the tree, typed into the title and run with
+// Enter, p on two papers to show how they connect, and n and N to step
+// through the matches
+func (t *TreeDisplay) keys(event *tcell.EventKey) *tcell.EventKey {
+	if t.searching {
+		switch event.Key() {
+		case tcell.KeyEnter:
+			t.searching = false
+			t.search()
+			return nil
+		case tcell.KeyEscape:
+			t.searching = false
+			t.SetTitle(treeTitle)
+			return nil
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if r := []rune(t.query); len(r) > 0 {
+				t.query = string(r[:len(r)-1])
+			}
+		case tcell.KeyRune:
+			t.query += string(event.Rune())
+		default:
+			return nil
+		}
+		t.SetTitle("Search: " + t.query + "_")
+		return nil
+	}
+	if event.Key() != tcell.KeyRune {
+		return event
+	}
+	switch event.Rune() {
+	case '/':
+		t.searching = true
+		t.query = ""
+		t.SetTitle("Search: _")
+	case 'n':
+		t.step(1)
+	case 'N':
+		t.step(-1)
+	case 'p':
+		t.path()
+	default:
+		return event
+	}
+	return nil
+}
+
+// search highlights the papers matching t.query, a tree.ParseQuery
+// expression, opening the tree down to them, and selects the first
+func (t *TreeDisplay) search() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.unhighlight()
+	q, err := tree.ParseQuery(t.query)
+	if err != nil {
+		t.SetTitle(fmt.Sprintf("%s: %s", treeTitle, err))
+		return
+	}
+	if t.ArxivHead == nil || q.Expr == "" {
+		t.SetTitle(treeTitle)
+		return
+	}
+	var found []*tree.ArxivTree
+	for _, h := range t.heads {
+		found = append(found, h.FindAll(q.Matcher())...)
+	}
+	if !t.highlight(found, "matching "+q.Expr) {
+		t.SetTitle(fmt.Sprintf("%s: nothing matches %s", treeTitle, q.Expr))
+	}
+}
+
+// unhighlight restores the colors highlight changed. Must hold t.mutex.
+func (t *TreeDisplay) unhighlight() {
+	for n, c := range t.colors {
+		n.SetColor(c)
+	}
+	clear(t.colors)
+	t.matches = nil
+}
+
+// highlight reveals and colors the nodes, found being what they are for
+// the title, and selects the first. It reports whether any could be
+// shown. Must hold t.mutex.
+func (t *TreeDisplay) highlight(nodes []*tree.ArxivTree, found string) bool {
+	for _, n := range nodes {
+		node := t.reveal(n)
+		if node == nil {
+			continue
+		}
+		t.colors[node] = node.GetColor()
+		node.SetColor(tcell.ColorFuchsia)
+		t.matches = append(t.matches, node)
+	}
+	t.match = 0
+	t.found = found
+	if len(t.matches) == 0 {
+		return false
+	}
+	t.SetCurrentNode(t.matches[0])
+	t.SetTitle(fmt.Sprintf("%s: 1/%d %s (n/N)", treeTitle, len(t.matches), found))
+	return true
+}
+
+// path remembers the selected paper the first time it is called, and the
+// second time highlights the shortest path between that paper and the
+// selected one: citations from the first to the second, else from the
+// second to the first, else either way
+func (t *TreeDisplay) path() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	node := t.GetCurrentNode()
+	if node == nil || t.Graph == nil {
+		return
+	}
+	n, ok := node.GetReference().(*tree.ArxivTree)
+	if !ok {
+		return
 	}
-	var node *tree.ArxivTree
-	for _, node := range t.Children {
-		if isTrue(node) {
-			return node
+	from := t.pathFrom
+	if from == nil || from.ID == n.Paper.ID {
+		t.pathFrom = n.Paper
+		t.SetTitle(fmt.Sprintf("%s: path from %.40s, press p on another paper", treeTitle, n.Paper.Title))
+		return
+	}
+	t.pathFrom = nil
+	t.unhighlight()
+	a, b := from.ID, n.Paper.ID
+	p, err := t.Graph.ShortestPath(a, b, tree.PathOptions{})
+	if err != nil {
+		p, err = t.Graph.ShortestPath(b, a, tree.PathOptions{})
+	}
+	if err != nil {
+		p, err = t.Graph.ShortestPath(a, b, tree.PathOptions{Undirected: true})
+	}
+	if err != nil {
+		t.SetTitle(fmt.Sprintf("%s: %s", treeTitle, err))
+		return
+	}
+	var nodes []*tree.ArxivTree
+	for _, id := range p {
+		match := tree.Query{IDs: []string{id}}.Matcher()
+		for _, h := range t.heads {
+			if m := h.Find(match); m != nil {
+				nodes = append(nodes, m)
+				break
+			}
+		}
+	}
+	t.highlight(nodes, fmt.Sprintf("on a %d citation path", p.Len()))
+}
+
+func (t *TreeDisplay) step(by int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.matches) == 0 {
+		return
+	}
+	t.match = (t.match + by + len(t.matches)) % len(t.matches)
+	t.SetCurrentNode(t.matches[t.match])
+	t.SetTitle(fmt.Sprintf("%s: %d/%d %s (n/N)", treeTitle, t.match+1, len(t.matches), t.found))
+}
+
+// reveal returns the tree view node showing n, adding and expanding the
+// nodes on the way to it. Must hold t.mutex.
+func (t *TreeDisplay) reveal(n *tree.ArxivTree) *tview.TreeNode {
+	node := t.GetRoot()
+	path := n.Path()
+	if node == nil {
+		return nil
+	}
+	if len(t.heads) > 1 {
+		// the root stands for every seed, each head is one of its children
+		var head *tview.TreeNode
+		for _, c := range node.GetChildren() {
+			if c.GetReference() == path[0] {
+				head = c
+			}
+		}
+		node = head
+	} else if path[0] != t.ArxivHead {
+		return nil
+	}
+	if node == nil {
+		return nil
+	}
+	for i := 1; i < len(path); i++ {
+		if len(node.GetChildren()) == 0 {
+			addNode(node, path[i-1])
+		}
+		node.SetExpanded(true)
+		var next *tview.TreeNode
+		for _, c := range node.GetChildren() {
+			if c.GetReference() == path[i] {
+				next = c
+				break
+			}
 		}
-		node = findNode(node, isTrue)
-		if node != nil {
-			return node
+		if next == nil {
+			return nil
 		}
+		node = next
 	}
 	return node
 }
@@ -1,6 +1,8 @@
 package tui
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -9,8 +11,11 @@ import (
 
 	"github.com/benjaminchristie/go-arxiv-tree/api"
 	log "github.com/benjaminchristie/go-arxiv-tree/arxiv_logger"
-	"github.com/benjaminchristie/go-arxiv-tree/comms"
+	"github.com/benjaminchristie/go-arxiv-tree/events"
+	"github.com/benjaminchristie/go-arxiv-tree/export"
+	"github.com/benjaminchristie/go-arxiv-tree/ledger"
 	ratelimiter "github.com/benjaminchristie/go-arxiv-tree/rate_limiter"
+	"github.com/benjaminchristie/go-arxiv-tree/shutdown"
 	"github.com/benjaminchristie/go-arxiv-tree/tree"
 	comps "github.com/benjaminchristie/go-arxiv-tree/tui/components"
 	"github.com/rivo/tview"
@@ -23,40 +28,56 @@ const (
 	LINE_IDX = 3
 	NET_IDX  = 4
 	TREE_IDX = 5
-
-	PDF_ARR_IDX = 0
-	LOG_ARR_IDX = 1
-	NET_ARR_IDX = 2
+	STAT_IDX = 6
 )
 
+var logger = log.Logger("tui")
+
 type FormData struct {
 	QueryType  string
 	QueryValue string
 	TreeDepth  int
 	OutputDir  string
 	SafeQuery  bool
+	Adaptive   bool
+	// zero values keep what the corresponding host limiter has
+	APIRate          float64
+	DownloadRate     float64
+	APIBurst         int
+	DownloadBurst    int
+	APIInFlight      int
+	DownloadInFlight int
+	// filter expressions, see tree.ParseFilter
+	ResolveFilter string
+	ExpandFilter  string
+	SnapshotFile  string // opened, saved and checkpointed to
+	Resume        bool   // continue the crawl checkpointed in SnapshotFile
 }
 
 type TUI struct {
 	App            *tview.Application
 	Components     []*comps.TUIPrimitive
-	Comms          [][]comms.Comm
 	Grid           *tview.Grid
-	TreeHead       *tree.ArxivTree
+	Graph          *tree.Graph
+	Read           map[string]bool // IDs marked read in the ranking
 	TreeUpdateChan chan bool
 	UpdateChan     chan bool
 	FormChan       chan FormData
+	Shutdown       *shutdown.Coordinator
+	Ledger         *ledger.Ledger // crawls are checked against its budget, if set
 }
 
-func MakeTUI() *TUI {
+func MakeTUI(coord *shutdown.Coordinator) *TUI {
 	var t *TUI
 
 	fData := FormData{
-		QueryType:  "Title",
-		QueryValue: "sample query",
-		TreeDepth:  1,
-		OutputDir:  "arxiv-download-folder",
-		SafeQuery:  false,
+		QueryType:    "Title",
+		QueryValue:   "sample query",
+		TreeDepth:    1,
+		OutputDir:    "arxiv-download-folder",
+		SafeQuery:    ratelimiter.IsEnabled(),
+		Adaptive:     ratelimiter.IsAdaptive(),
+		SnapshotFile: "arxiv-tree.json",
 	}
 	onDropDown := func(s string, _ int) {
 		fData.QueryType = s
@@ -77,16 +98,75 @@ func MakeTUI() *TUI {
 	onLimit := func(b bool) {
 		fData.SafeQuery = b
 	}
+	onAdaptive := func(b bool) {
+		fData.Adaptive = b
+	}
+	onAPIRate := func(s string) {
+		fData.APIRate, _ = strconv.ParseFloat(s, 64)
+	}
+	onDownloadRate := func(s string) {
+		fData.DownloadRate, _ = strconv.ParseFloat(s, 64)
+	}
+	onAPIBurst := func(s string) {
+		fData.APIBurst, _ = strconv.Atoi(s)
+	}
+	onDownloadBurst := func(s string) {
+		fData.DownloadBurst, _ = strconv.Atoi(s)
+	}
+	onAPIInFlight := func(s string) {
+		fData.APIInFlight, _ = strconv.Atoi(s)
+	}
+	onDownloadInFlight := func(s string) {
+		fData.DownloadInFlight, _ = strconv.Atoi(s)
+	}
+	onResolveFilter := func(s string) {
+		fData.ResolveFilter = s
+	}
+	onExpandFilter := func(s string) {
+		fData.ExpandFilter = s
+	}
+	onSnapshot := func(s string) {
+		fData.SnapshotFile = s
+	}
+	onOpen := func() {
+		go func() {
+			err := t.Open(fData.SnapshotFile)
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		}()
+	}
+	onSave := func() {
+		go func() {
+			err := t.Save(fData.SnapshotFile)
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		}()
+	}
 	onStart := func() {
 		go func() {
 			t.FormChan <- fData
 		}()
 	}
+	onResume := func() {
+		f := fData
+		f.Resume = true
+		go func() {
+			t.FormChan <- f
+		}()
+	}
+	onExport := func() {
+		t.showExport()
+	}
+	onRank := func() {
+		t.showRanking()
+	}
 	onQuit := func() {
 		t.App.Stop()
 	}
 
-	N_COMPONENTS := 6
+	N_COMPONENTS := 7
 	app := tview.NewApplication()
 	grid := tview.NewGrid()
 
@@ -96,33 +176,20 @@ func MakeTUI() *TUI {
 
 	components := make([]*comps.TUIPrimitive, N_COMPONENTS)
 
-	tuiComms := make([][]comms.Comm, 3)
-	tuiComms[PDF_ARR_IDX] = make([]comms.Comm, 2)
-	tuiComms[NET_ARR_IDX] = make([]comms.Comm, 1)
-	tuiComms[LOG_ARR_IDX] = make([]comms.Comm, 1)
-	tuiComms[PDF_ARR_IDX][0] = *comms.MakeComm(0)
-	tuiComms[PDF_ARR_IDX][1] = *comms.MakeComm(0, func(i interface{}) interface{} {
-		return t.App.GetFocus() != components[TREE_IDX].Primitive
-	},
-	)
-	tuiComms[NET_ARR_IDX][0] = *comms.MakeComm(0, func(i interface{}) interface{} {
-		s, ok := i.(string)
-		if !ok {
-			log.Printf("Error casting to string in NET_ARR_IDX callback")
-			return s
-		}
-		return api.NetData{
-			Message: s[:min(len(s), 1024)],
-			Size:    len(s),
-		}
-	})
-	tuiComms[LOG_ARR_IDX][0] = *comms.MakeComm(0)
+	components[FORM_IDX] = comps.MakeForm(onDropDown, onSearch, onDir, onDepth, onLimit, onAdaptive, onAPIRate, onDownloadRate, onAPIBurst, onDownloadBurst, onAPIInFlight, onDownloadInFlight, onResolveFilter, onExpandFilter, onSnapshot, onStart, onResume, onOpen, onSave, onExport, onRank, onQuit)
+	// the checkboxes start out as the flags left the limiters
+	form := components[FORM_IDX].Primitive.(*tview.Form)
+	form.GetFormItemByLabel("Avoid Rate Limit: ").(*tview.Checkbox).SetChecked(fData.SafeQuery)
+	form.GetFormItemByLabel("Adaptive: ").(*tview.Checkbox).SetChecked(fData.Adaptive)
+	components[LOG_IDX] = comps.MakeLogs()
+	components[PDF_IDX] = comps.MakePDFLogs(events.Bus)
+	components[LINE_IDX], components[NET_IDX] = comps.MakeNet(events.Bus)
+	components[TREE_IDX] = comps.MakeTreeDisplayComponent(nil, events.Bus)
+	components[STAT_IDX] = comps.MakeStatus()
 
-	components[FORM_IDX] = comps.MakeForm(onDropDown, onSearch, onDir, onDepth, onLimit, onStart, onQuit)
-	components[LOG_IDX] = comps.MakeLogs(&tuiComms[LOG_ARR_IDX][0])
-	components[PDF_IDX] = comps.MakePDFLogs(&tuiComms[PDF_ARR_IDX][0])
-	components[LINE_IDX], components[NET_IDX] = comps.MakeNet(&tuiComms[NET_ARR_IDX][0])
-	components[TREE_IDX] = comps.MakeTreeDisplayComponent(nil, &tuiComms[PDF_ARR_IDX][1])
+	components[TREE_IDX].Primitive.(*comps.TreeDisplay).Spawn = func(fn func(ctx context.Context)) {
+		coord.Go(fn)
+	}
 
 	t = &TUI{
 		App:            app,
@@ -131,31 +198,48 @@ func MakeTUI() *TUI {
 		TreeUpdateChan: treeUpdateChan,
 		UpdateChan:     updateChan,
 		FormChan:       formChan,
-		TreeHead:       nil,
-		Comms:          tuiComms,
+		Graph:          nil,
+		Read:           make(map[string]bool),
+		Shutdown:       coord,
 	}
 	return t
 }
 
 func (t *TUI) Run() {
-	log.Printf("Beginning Run")
+	logger.Debug("beginning run")
 	for _, c := range t.Components {
-		log.Printf("Adding %v %p", c, c)
+		logger.Debug("adding component", "component", fmt.Sprintf("%p", c))
 		comps.AddToGrid(t.Grid, c)
 	}
 
 	go func() {
+		stopping := t.Shutdown.Context().Done()
 		for {
-			formData, ok := <-t.FormChan
-			if !ok {
-				log.Printf("Continuing")
-				continue
+			var formData FormData
+			select {
+			case formData = <-t.FormChan:
+			case <-stopping:
+				return
+			}
+			// run as a worker so shutdown waits for the crawl to drain
+			done := make(chan bool)
+			started := t.Shutdown.Go(func(ctx context.Context) {
+				defer close(done)
+				t.formSubmit(ctx, formData)
+			})
+			if !started {
+				return
 			}
-			t.formSubmit(formData)
+			<-done
 		}
 	}()
 
-	log.Printf("Running")
+	go func() {
+		<-t.Shutdown.Context().Done()
+		t.App.Stop()
+	}()
+
+	logger.Debug("running")
 
 	go func() {
 		ticker := time.NewTicker(time.Second / 15) // run at 15Hz
@@ -170,33 +254,176 @@ func (t *TUI) Run() {
 	}
 }
 
-func (t *TUI) sendLogs(s string, v ...any) {
-	log.Printf(s, v...)
-	for _, c := range t.Comms[LOG_ARR_IDX] {
-		c.Send(fmt.Sprintf(s, v...))
+// Open shows a saved graph in the tree view
+func (t *TUI) Open(filename string) error {
+	g, err := tree.Load(filename)
+	if err != nil {
+		return err
 	}
+	t.Graph = g
+	t.Components[TREE_IDX].Primitive.(*comps.TreeDisplay).SetGraph(g)
+	t.sendLogs("Opened %d papers from %s", g.Len(), filename)
+	return nil
 }
-func (t *TUI) sendPDFLogs(s string, v ...any) {
-	log.Printf(s, v...)
-	for _, c := range t.Comms[PDF_ARR_IDX] {
-		c.Send(fmt.Sprintf(s, v...))
+
+// Save writes the graph in the tree view to filename
+func (t *TUI) Save(filename string) error {
+	if t.Graph == nil {
+		return errors.New("nothing to save yet, start a search first")
 	}
+	err := tree.Save(t.Graph, filename)
+	if err != nil {
+		return err
+	}
+	t.sendLogs("Saved %d papers to %s", t.Graph.Len(), filename)
+	return nil
+}
+
+// showExport replaces the grid with the export dialog until it is closed
+func (t *TUI) showExport() {
+	if t.Graph == nil {
+		logger.Error("nothing to export yet, start a search first")
+		return
+	}
+	g := t.Graph
+	closeDialog := func() {
+		t.App.SetRoot(t.Grid, true)
+	}
+	dialog := comps.MakeExportDialog("arxiv-tree.graphml",
+		func(format, filename string) {
+			closeDialog()
+			err := export.WriteFile(g, filename, format)
+			if err != nil {
+				logger.Error(err.Error())
+				return
+			}
+			t.sendLogs("Exported %d papers to %s", g.Len(), filename)
+		},
+		closeDialog,
+	)
+	t.App.SetRoot(dialog, true)
 }
 
-func (t *TUI) formSubmit(f FormData) {
+// showRanking replaces the grid with the papers ranked by centrality until
+// it is closed
+func (t *TUI) showRanking() {
+	if t.Graph == nil {
+		logger.Error("nothing to rank yet, start a search first")
+		return
+	}
+	t.App.SetRoot(comps.MakeRanking(t.Graph, t.Read, func() {
+		t.App.SetRoot(t.Grid, true)
+	}), true)
+}
+
+func (t *TUI) sendLogs(s string, v ...any) {
+	logger.Info(fmt.Sprintf(s, v...))
+}
+
+func (t *TUI) formSubmit(ctx context.Context, f FormData) {
 	var err error
-	log.Printf("In form submit")
-	go t.sendLogs("Parsing Query")
+	logger.Debug("in form submit")
+	t.sendLogs("Parsing Query")
 
 	if f.SafeQuery {
 		ratelimiter.Enable()
+	} else {
+		ratelimiter.Disable()
+	}
+	if f.APIRate != 0 || f.APIBurst != 0 || f.APIInFlight != 0 {
+		ratelimiter.Tune(api.API_HOST, f.APIRate, f.APIBurst, f.APIInFlight)
+	}
+	if f.DownloadRate != 0 || f.DownloadBurst != 0 || f.DownloadInFlight != 0 {
+		ratelimiter.Tune(api.DOWNLOAD_HOST, f.DownloadRate, f.DownloadBurst, f.DownloadInFlight)
+	}
+	// setting it again would throw away any backoff in progress
+	if f.Adaptive != ratelimiter.IsAdaptive() {
+		ratelimiter.SetAdaptive(f.Adaptive)
 	}
 	defer func() {
 		time.Sleep(1 * time.Second)
 		t.sendLogs("Awaiting New Query")
 	}()
 
-	info := tree.ArxivTreeInfo{
+	var c tree.CrawlConfig
+	if f.Resume {
+		t.Graph, err = tree.Load(f.SnapshotFile)
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+		// same limits as the interrupted crawl, checkpointing to the same file
+		c = t.Graph.Config()
+		c.Checkpoint = f.SnapshotFile
+		t.sendLogs("Resuming %s with %d papers", f.SnapshotFile, t.Graph.Len())
+	} else {
+		c = tree.CrawlConfig{
+			Depth:      f.TreeDepth,
+			Resolve:    f.ResolveFilter,
+			Expand:     f.ExpandFilter,
+			Checkpoint: f.SnapshotFile, // where Resume looks for it
+		}
+		// before the query, so a typo costs no requests
+		for _, expr := range []string{c.Resolve, c.Expand} {
+			if _, err := tree.ParseFilter(expr); err != nil {
+				logger.Error("bad filter", log.Err(err))
+				return
+			}
+		}
+		t.Graph, err = t.findRoot(ctx, f)
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+	}
+	// yuck
+	t.Components[TREE_IDX].Primitive.(*comps.TreeDisplay).SetGraph(t.Graph)
+
+	err = os.MkdirAll(f.OutputDir, 0755)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	// progress reaches the panes through events.Bus, the callback only
+	// downloads. It runs in a goroutine, once per paper
+	g := t.Graph
+	if t.Ledger != nil {
+		// a warning only, the ledger refuses requests once the budget is used up
+		err = t.Ledger.Check(tree.EstimateRequests(c, len(g.Seeds())))
+		if err != nil {
+			t.sendLogs("Warning: this crawl may exceed the request budget: %s", err)
+		}
+	}
+	err = tree.Crawl(ctx, g, c, func(n tree.Node) {
+		t.downloadPDFhelper(ctx, g, n, f.OutputDir)
+	})
+	if err != nil && ctx.Err() == nil {
+		t.sendLogs("Crawl stopped early (%s), resume from %s", err, c.Checkpoint)
+	}
+	if t.Ledger != nil && (t.Ledger.Budget.Hourly > 0 || t.Ledger.Budget.Daily > 0) {
+		hourly, daily, _ := t.Ledger.Remaining()
+		t.sendLogs("Request budget left: %s this hour, %s today", budgetLeft(hourly), budgetLeft(daily))
+	}
+}
+
+// budgetLeft formats what Ledger.Remaining returns, -1 meaning no budget
+func budgetLeft(n int) string {
+	if n < 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(n)
+}
+
+// findRoot looks up the paper the form asks for and starts a graph at it.
+// Searching by "Seeds" takes an ID or title per line and starts at all of
+// them.
+func (t *TUI) findRoot(ctx context.Context, f FormData) (*tree.Graph, error) {
+	if f.QueryType == "Seeds" {
+		logger.Info("looking up seeds", "seeds", f.QueryValue, log.Depth(f.TreeDepth), "output", f.OutputDir)
+		qctx := ratelimiter.WithClass(ctx, ratelimiter.Interactive)
+		return tree.MakeSeededGraph(qctx, strings.Split(f.QueryValue, "\n"))
+	}
+	info := tree.Paper{
 		Title:      "",
 		ID:         "",
 		Author:     "",
@@ -215,52 +442,28 @@ func (t *TUI) formSubmit(f FormData) {
 		query.Title = f.QueryValue
 	}
 
-	log.Printf("Parsing query with parameters %s, depth: %d, output: %s", f.QueryValue, f.TreeDepth, f.OutputDir)
-	err = tree.MakeInfoFromQuery(&info, query, true, t.Comms[NET_ARR_IDX]...)
-	if err != nil {
-		log.Print(err)
-		t.sendLogs("Error: %s", err.Error())
-		return
-	}
-
-	t.TreeHead = &tree.ArxivTree{
-		Head:     nil,
-		Children: nil,
-		Value:    info,
-	}
-	// yuck
-	t.Components[TREE_IDX].Primitive.(*comps.TreeDisplay).UpdateHead(t.TreeHead)
-
-	err = os.MkdirAll(f.OutputDir, 0755)
+	logger.Info("parsing query", "query", f.QueryValue, "type", f.QueryType, log.Depth(f.TreeDepth), "output", f.OutputDir)
+	qctx := ratelimiter.WithClass(ctx, ratelimiter.Interactive)
+	err := tree.MakeInfoFromQueryContext(qctx, &info, query, true)
 	if err != nil {
-		log.Print(err)
-		t.sendLogs("Error: %s", err.Error())
-		return
+		return nil, err
 	}
-	// callback to populateTree is goroutine
-	tree.PopulateTree(t.TreeHead, f.TreeDepth,
-		func(n *tree.ArxivTree) {
-			go t.sendLogs("Populating Tree for %s", n.Value.(tree.ArxivTreeInfo).Title)
-			t.downloadPDFhelper(n, f.OutputDir)
-		},
-		t.Comms[NET_ARR_IDX]...,
-	)
+	return tree.MakeGraph(info), nil
 }
 
-func (t *TUI) downloadPDFhelper(n *tree.ArxivTree, outputDir string) {
-	id := n.Value.(tree.ArxivTreeInfo).ID
-	au := n.Value.(tree.ArxivTreeInfo).Author
-	ti := n.Value.(tree.ArxivTreeInfo).Title
+func (t *TUI) downloadPDFhelper(ctx context.Context, g *tree.Graph, n tree.Node, outputDir string) {
+	id := n.ID
+	au := n.Info.Author
+	ti := n.Info.Title
 	if id != "" {
 		formatted := fmt.Sprintf("%s/%s_%s.pdf", outputDir, strings.Replace(ti, "/", "", -1), id)
-		err := api.DownloadPDF(id, formatted, t.Comms[NET_ARR_IDX]...)
+		err := api.DownloadPDFContext(ctx, id, formatted)
 		if err != nil {
-			log.Print(err)
-			t.sendLogs("Error: %s", err.Error())
+			logger.Error(err.Error())
+			g.Fail("", n.Info, n.Depth, "pdf", err)
 			return
 		}
-		m := fmt.Sprintf("PDF: %.20s: %.60s", au, ti)
-		t.sendPDFLogs(m)
+		t.sendLogs("PDF: %.20s: %.60s", au, ti)
 	} else {
 		m := fmt.Sprintf("Could not download PDF %.40s", ti)
 		t.sendLogs(m)